@@ -0,0 +1,3 @@
+module github.com/nuominmin/match-room-demo
+
+go 1.23
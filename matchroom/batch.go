@@ -0,0 +1,128 @@
+package matchroom
+
+import "sync"
+
+// 单房间匹配 - 返回选中候选的详情及全部候选详情，批量匹配对每个房间调用一次
+// 随机源和是否使用拒绝缓存由提供方决定；并发批量匹配时会被多个协程同时调用，必须并发安全
+type roomMatchFunc func(room *Entity, pool []*Entity, userID string) (*MatchDetail, []*MatchDetail)
+
+// 批量匹配优化 - 为多个同时匹配
+func batchMatchEntities(rooms []*Entity, pool []*Entity, userIDs []string, config *MatchConfig, match roomMatchFunc) map[string]*Entity {
+	if len(rooms) != len(userIDs) {
+		panic("rooms and userIDs length mismatch")
+	}
+
+	results := make(map[string]*Entity, len(rooms))
+
+	// 独占分配时使用候选池副本，选中的候选从后续房间的候选中移除
+	// 因此靠前的房间优先选择，结果依赖 rooms 的顺序
+	available := pool
+	if config.ExclusiveAssignment {
+		available = append([]*Entity(nil), pool...)
+	}
+
+	// 为每个进行匹配
+	for i, room := range rooms {
+		if room == nil || i >= len(userIDs) {
+			continue
+		}
+
+		userID := userIDs[i]
+		selected, _ := match(room, available, userID)
+		if matched := selected.entity(); matched != nil {
+			results[room.ID] = matched
+			if config.ExclusiveAssignment {
+				available = removeEntity(available, matched)
+			}
+		}
+	}
+
+	return results
+}
+
+// 批量详细匹配 - 返回每个房间的完整匹配结果，详情中只引用候选池中的实体，不复制候选池
+// 与 batchMatchEntities 一致地支持 ExclusiveAssignment；没有匹配的房间同样返回其详情
+func batchMatchEntitiesDetailed(rooms []*Entity, pool []*Entity, userIDs []string, config *MatchConfig, match roomMatchFunc) map[string]*MatchOutcome {
+	if len(rooms) != len(userIDs) {
+		panic("rooms and userIDs length mismatch")
+	}
+
+	results := make(map[string]*MatchOutcome, len(rooms))
+	available := pool
+	if config.ExclusiveAssignment {
+		available = append([]*Entity(nil), pool...)
+	}
+
+	for i, room := range rooms {
+		if room == nil {
+			continue
+		}
+
+		selected, details := match(room, available, userIDs[i])
+		outcome := newMatchOutcome(selected, details, config)
+		results[room.ID] = outcome
+		if config.ExclusiveAssignment && outcome.Winner != nil {
+			available = removeEntity(available, outcome.Winner)
+		}
+	}
+
+	return results
+}
+
+// 移除实体 - 保持其余候选顺序不变
+func removeEntity(pool []*Entity, target *Entity) []*Entity {
+	for i, entity := range pool {
+		if entity == target {
+			return append(pool[:i], pool[i+1:]...)
+		}
+	}
+	return pool
+}
+
+// 并发批量匹配 - 按 concurrency 个 worker 分发每个房间的匹配，结果与串行版本形式一致
+// pool 在匹配过程中只读，match 需并发安全
+// 独占分配必须按顺序进行，开启 ExclusiveAssignment 时退化为串行匹配
+func batchMatchEntitiesConcurrent(rooms []*Entity, pool []*Entity, userIDs []string, config *MatchConfig, concurrency int, match roomMatchFunc) map[string]*Entity {
+	if len(rooms) != len(userIDs) {
+		panic("rooms and userIDs length mismatch")
+	}
+	if config.ExclusiveAssignment {
+		return batchMatchEntities(rooms, pool, userIDs, config, match)
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	results := make(map[string]*Entity, len(rooms))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				room := rooms[i]
+				selected, _ := match(room, pool, userIDs[i])
+				matched := selected.entity()
+				if matched == nil {
+					continue
+				}
+				mu.Lock()
+				results[room.ID] = matched
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i, room := range rooms {
+		if room != nil {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
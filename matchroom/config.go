@@ -0,0 +1,444 @@
+package matchroom
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+// 打分权重 - 各项子得分的乘数，全部为1时与原逻辑一致
+// 权重必须为正，Validate 拒绝为0的权重；默认值只用于 JSON 中未出现的字段，代码中只调整部分权重时应复制 DefaultScoreWeights 后修改
+// 要关闭某项打分，从 MatchConfig.Scorers 中去掉对应打分器
+type ScoreWeights struct {
+	WaitWeight     float64 `json:"wait_weight"`     // 等待时间权重
+	SegmentWeight  float64 `json:"segment_weight"`  // 段位一致性权重
+	AudienceWeight float64 `json:"audience_weight"` // 观众差异权重
+	HistoryWeight  float64 `json:"history_weight"`  // 历史匹配权重
+	ActivityWeight float64 `json:"activity_weight"` // 活跃度权重
+	LanguageWeight float64 `json:"language_weight"` // 语言偏好权重
+}
+
+var DefaultScoreWeights = ScoreWeights{
+	WaitWeight:     1,
+	SegmentWeight:  1,
+	AudienceWeight: 1,
+	HistoryWeight:  1,
+	ActivityWeight: 1,
+	LanguageWeight: 1,
+}
+
+// 匹配配置 - 将魔数提取为配置
+type MatchConfig struct {
+	RecentMatchCooldown    int64                  `json:"recent_match_cooldown"`    // 冷却时间（秒）
+	CooldownByActivity     [3]int64               `json:"cooldown_by_activity"`     // 按候选活跃度的冷却时间（low, medium, high），为0时使用 RecentMatchCooldown
+	MaxWaitTime            int                    `json:"max_wait_time"`            // 最大等待时间
+	MinWaitTime            int                    `json:"min_wait_time"`            // 最小等待时间
+	WaitCurve              WaitCurve              `json:"wait_curve"`               // 等待时间得分曲线，默认为 WaitCurveLegacy
+	Weights                ScoreWeights           `json:"weights"`                  // 打分权重
+	InitiatorWeights       *ScoreWeights          `json:"initiator_weights"`        // 发起方视角的打分权重，为 nil 时与被匹配方共用 Weights
+	Scorers                []Scorer               `json:"-"`                        // 自定义打分器，为空时使用 DefaultScorers
+	Filters                []EntityFilter         `json:"-"`                        // 自定义准入过滤，在打分前依次执行
+	OnScored               func(*MatchDetail)     `json:"-"`                        // 每个候选打分后调用，用于逐条上报指标；传入的是详情副本，修改不影响匹配结果
+	RejectObserver         RejectObserver         `json:"-"`                        // 候选被拒绝时调用，为 nil 时不通知；拒绝缓存命中、Pool 匹配及 RescoreWait 重算中被拒绝的候选同样通知
+	ExclusiveAssignment    bool                   `json:"exclusive_assignment"`     // 批量匹配时每个候选最多分配给一个房间（结果依赖房间顺序）
+	MinAcceptableScore     int16                  `json:"min_acceptable_score"`     // 最低可接受分数，低于此分数的候选被拒绝，为0时不启用；开启 NormalizeScore 时按0-100计
+	MinMicCount            uint16                 `json:"min_mic_count"`            // 候选最少上麦人数，为0时不限制
+	MaxAudienceCount       uint16                 `json:"max_audience_count"`       // 候选最多观众人数，超过视为已满，为0时不限制
+	TieBreak               TieBreakMode           `json:"tie_break"`                // 最高分并列时的选择方式
+	AudienceMode           AudienceMode           `json:"audience_mode"`            // 观众人数打分方式
+	AudienceDiffScores     []int16                `json:"audience_diff_scores"`     // 观众差分值表，下标为观众人数差，为空时使用默认分值表
+	AudienceBucketSize     uint16                 `json:"audience_bucket_size"`     // 观众分桶宽度，绝对差模式下按桶下标之差打分，如宽度10时50与51同桶；为0时按原始人数打分
+	TargetAudience         uint16                 `json:"target_audience"`          // 目标观众人数，低于目标的候选按 1-观众/目标 比例加分，为0时不启用
+	FillMaxScore           int16                  `json:"fill_max_score"`           // 空房间的补位得分，观众达到目标时为0
+	FillOverPenalty        bool                   `json:"fill_over_penalty"`        // 超过目标的候选按同一比例扣分（最多扣 FillMaxScore），默认超过目标时得0分
+	StrictSegment          bool                   `json:"strict_segment"`           // 严格段位模式，段位不同一律拒绝，不受等待时间影响
+	SegmentMap             *SegmentMap            `json:"segment_map"`              // 自定义段位表，为 nil 时使用默认的四段分段
+	SegmentDistanceScores  []SegmentDistanceScore `json:"segment_distance_scores"`  // 按段位距离的得分和等待门槛，下标为距离；为 nil 时同段10分、相邻段等待60秒后3分、更远等待60秒后0分
+	Locale                 string                 `json:"locale"`                   // 拒绝原因语言（LocaleZh/LocaleEn），为空时使用中文
+	RegionBonus            int16                  `json:"region_bonus"`             // 同地区加分
+	RegionPenalty          int16                  `json:"region_penalty"`           // 跨地区扣分，按正数填写
+	RejectCrossRegion      bool                   `json:"reject_cross_region"`      // 跨地区直接拒绝
+	CompatibleLanguages    map[string][]string    `json:"compatible_languages"`     // 可互通的语言，语言: 兼容语言列表，任一方向配置即视为兼容
+	SoftCooldown           bool                   `json:"soft_cooldown"`            // 软冷却模式，冷却期内不拒绝而是由 CooldownScorer 按剩余冷却时间扣分；自定义 Scorers 不含 CooldownScorer 时仍直接拒绝
+	CooldownPenalty        int16                  `json:"cooldown_penalty"`         // 软冷却最大扣分，刚匹配过时扣满，冷却结束时为0；开启 SoftCooldown 时必须为正
+	SuccessRateMaxScore    int16                  `json:"success_rate_max_score"`   // 成功率得分上限，成功率100%时得满分，为0时不启用
+	MutualHistoryBonus     int16                  `json:"mutual_history_bonus"`     // 双方互相有冷却期外的匹配记录时加分
+	MutualHistoryWindow    int64                  `json:"mutual_history_window"`    // 互相匹配记录的有效窗口（秒），超过视为过旧，为0时不限制
+	BalanceMaxScore        int16                  `json:"balance_max_score"`        // 角色平衡得分上限，候选完全消除当前角色失衡时得满分，为0时不启用
+	TagMaxScore            int16                  `json:"tag_max_score"`            // 标签重合得分上限，按 Jaccard 相似度（交集/并集）给分，为0时不启用
+	FreshnessMaxScore      int16                  `json:"freshness_max_score"`      // 新鲜度得分上限，刚活跃过时得满分，为0时不启用
+	FreshnessHalfLife      int64                  `json:"freshness_half_life"`      // 新鲜度半衰期（秒），距上次活跃每过一个半衰期得分减半，为0时不启用
+	TimeOfDayBonus         int16                  `json:"time_of_day_bonus"`        // 当前小时在候选偏好时段内时加分，候选无偏好时不计分，为0时不启用
+	TimeOfDayOffset        int                    `json:"time_of_day_offset"`       // 计算当前小时使用的时区偏移（秒，东区为正），为0时按 UTC
+	ActivityMomentumBonus  int16                  `json:"activity_momentum_bonus"`  // 活跃度升级加成上限，刚进入中高活跃度时加满，为0时不启用
+	ActivityMomentumWindow int64                  `json:"activity_momentum_window"` // 活跃度升级加成持续时间（秒），期间线性衰减
+	AllowSelfMatch         bool                   `json:"allow_self_match"`         // 允许候选与当前实体ID相同，默认拒绝自身
+	NormalizeScore         bool                   `json:"normalize_score"`          // 将总分归一化到0-100，Score 为归一化分数，RawScore 保留原始加权总分
+	ExplainMode            bool                   `json:"explain_mode"`             // 解释模式，被拒绝的候选仍计算并记录全部子得分，用于分析（较慢）
+	FallbackStrategy       FallbackStrategy       `json:"fallback_strategy"`        // 没有有效候选时的兜底策略，默认不兜底
+	MaxCandidatesScored    int                    `json:"max_candidates_scored"`    // 每次匹配最多打分的候选数，超出部分不再打分，为0时不限制；以最优性换取延迟
+	GoodEnoughScore        int16                  `json:"good_enough_score"`        // 候选得分达到该值时立即停止打分并在已打分候选中选择，为0时不启用
+	DataTTL                int64                  `json:"data_ttl"`                 // 候选补充数据的有效期（秒），距 DataFetchedAtUnix 超过该值时拒绝（未拉取过的视为过期），为0时不检查
+	LivenessTimeout        int64                  `json:"liveness_timeout"`         // 候选最近活跃时间距今超过该值（秒）时视为僵尸房间并拒绝（活跃时间未知的同样拒绝），为0时不检查
+	QualityThresholds      QualityThresholds      `json:"quality_thresholds"`       // 匹配质量分数线，全部为0时使用默认分数线
+	RejectCacheTTL         int64                  `json:"reject_cache_ttl"`         // 引擎记住被拒绝的 (当前实体, 用户, 候选) 组合的时长（秒），期间直接沿用拒绝结果不再打分；冷却、临时屏蔽、段位拒绝最多缓存到解除时刻，上麦人数变化后不再沿用；观众已满、数据过期、不活跃、分数线和自定义过滤、打分器的拒绝不缓存，为0时不启用
+	RejectCacheSize        int                    `json:"reject_cache_size"`        // 拒绝缓存容量，为0时使用默认容量
+	TierMinScores          []int16                `json:"tier_min_scores"`          // MatchTiered 每层的最低分，下标对应候选池顺序，缺省的层不限制
+	StickinessBonus        int16                  `json:"stickiness_bonus"`         // MatchSticky 中上一次选中的候选加分，新候选需高出该分数才会替换，为0时不启用
+}
+
+// 兜底策略枚举 - 所有候选都被拒绝或最高分为负数时的处理方式
+type FallbackStrategy uint8
+
+const (
+	FallbackNone         FallbackStrategy = iota // 不兜底，返回 nil（默认）
+	FallbackRandomAny                            // 随机选择一个未被拉黑的候选
+	FallbackBestRejected                         // 忽略拒绝，选择按 RawScore 得分最高的未被拉黑候选
+)
+
+// 通知打分结果 - 传入副本，回调无法改变分数或拒绝标记；副本与原详情共享 Entity，回调不应修改实体
+func (c *MatchConfig) notifyScored(detail *MatchDetail) {
+	if c.OnScored == nil {
+		return
+	}
+	copied := *detail
+	c.OnScored(&copied)
+}
+
+// 拒绝观察者 - 每个候选被拒绝时调用，用于把拒绝事件实时推送到指标系统
+// 拒绝缓存命中时每次匹配都会再通知一次；可能被多个协程同时调用，实现需自行保证并发安全
+type RejectObserver interface {
+	OnReject(detail *MatchDetail)
+}
+
+// 通知拒绝 - 与 notifyScored 相同传入副本；未设置观察者时不做任何事
+func (c *MatchConfig) notifyRejected(detail *MatchDetail) {
+	if c.RejectObserver == nil {
+		return
+	}
+	copied := *detail
+	c.RejectObserver.OnReject(&copied)
+}
+
+// 是否计算被拒绝候选的全部子得分 - BestRejected 兜底需要被拒绝候选的 RawScore
+func (c *MatchConfig) explain() bool {
+	return c.ExplainMode || c.FallbackStrategy == FallbackBestRejected
+}
+
+// 观众人数打分方式枚举
+type AudienceMode uint8
+
+const (
+	AudienceAbsolute AudienceMode = iota // 按观众人数绝对差打分（默认）
+	AudienceRatio                        // 按观众人数比例（较小/较大）打分，与规模无关
+)
+
+// 准入过滤函数 - 返回 true 时以 reason 拒绝候选，用于地区封禁、年龄限制等业务规则
+type EntityFilter func(current, candidate *Entity) (reject bool, reason string)
+
+// 并列选择方式枚举
+type TieBreakMode uint8
+
+const (
+	TieBreakUniform        TieBreakMode = iota // 均匀随机（默认）
+	TieBreakPreferActivity                     // 按活跃度得分加权随机，高活跃度更容易被选中
+	TieBreakLowestID                           // 选择ID字典序最小的候选，完全不使用随机数，用于审计环境
+	TieBreakLongestWait                        // 选择等待时间最长的候选，等待时间也相同时均匀随机
+)
+
+var DefaultMatchConfig = MatchConfig{
+	RecentMatchCooldown: 600, // 10分钟
+	MaxWaitTime:         300, // 5分钟
+	MinWaitTime:         20,  // 20秒
+	Weights:             DefaultScoreWeights,
+	AudienceDiffScores:  slices.Clone(audienceDiffScores), // 复制一份，调用方原地修改默认配置时不影响包级分值表
+}
+
+// 配置校验 - 检查等待时间区间、冷却时间和权重是否合理
+func (c *MatchConfig) Validate() error {
+	if c.MinWaitTime < 0 {
+		return fmt.Errorf("MinWaitTime must be non-negative, got %d", c.MinWaitTime)
+	}
+	if c.MinWaitTime > c.MaxWaitTime {
+		return fmt.Errorf("MinWaitTime (%d) must not exceed MaxWaitTime (%d)", c.MinWaitTime, c.MaxWaitTime)
+	}
+	if c.WaitCurve > WaitCurveLogarithmic {
+		return fmt.Errorf("unknown WaitCurve %d", c.WaitCurve)
+	}
+	if c.WaitCurve != WaitCurveLegacy && c.MaxWaitTime <= c.MinWaitTime {
+		return fmt.Errorf("WaitCurve %d requires MaxWaitTime (%d) greater than MinWaitTime (%d)", c.WaitCurve, c.MaxWaitTime, c.MinWaitTime)
+	}
+	if c.TieBreak > TieBreakLongestWait {
+		return fmt.Errorf("unknown TieBreak %d", c.TieBreak)
+	}
+	if c.FallbackStrategy > FallbackBestRejected {
+		return fmt.Errorf("unknown FallbackStrategy %d", c.FallbackStrategy)
+	}
+	if t := c.QualityThresholds; t.Excellent < t.Good || t.Good < t.Fair {
+		return fmt.Errorf("QualityThresholds must satisfy Excellent >= Good >= Fair, got %d/%d/%d", t.Excellent, t.Good, t.Fair)
+	}
+	for distance, entry := range c.SegmentDistanceScores {
+		if entry.Score < 0 {
+			return fmt.Errorf("SegmentDistanceScores[%d].Score must be non-negative, got %d", distance, entry.Score)
+		}
+	}
+	if c.SegmentMap != nil {
+		if err := c.SegmentMap.validate(); err != nil {
+			return err
+		}
+	}
+	if c.RejectCacheTTL < 0 {
+		return fmt.Errorf("RejectCacheTTL must be non-negative, got %d", c.RejectCacheTTL)
+	}
+	if c.RejectCacheSize < 0 {
+		return fmt.Errorf("RejectCacheSize must be non-negative, got %d", c.RejectCacheSize)
+	}
+	if c.LivenessTimeout < 0 {
+		return fmt.Errorf("LivenessTimeout must be non-negative, got %d", c.LivenessTimeout)
+	}
+	if c.DataTTL < 0 {
+		return fmt.Errorf("DataTTL must be non-negative, got %d", c.DataTTL)
+	}
+	if c.MaxCandidatesScored < 0 {
+		return fmt.Errorf("MaxCandidatesScored must be non-negative, got %d", c.MaxCandidatesScored)
+	}
+	if c.RecentMatchCooldown < 0 {
+		return fmt.Errorf("RecentMatchCooldown must be non-negative, got %d", c.RecentMatchCooldown)
+	}
+	for i, cooldown := range c.CooldownByActivity {
+		if cooldown < 0 {
+			return fmt.Errorf("CooldownByActivity[%s] must be non-negative, got %d", ActivityLevel(i), cooldown)
+		}
+	}
+	if c.SuccessRateMaxScore < 0 {
+		return fmt.Errorf("SuccessRateMaxScore must be non-negative, got %d", c.SuccessRateMaxScore)
+	}
+	if c.RegionBonus < 0 {
+		return fmt.Errorf("RegionBonus must be non-negative, got %d", c.RegionBonus)
+	}
+	if c.RegionPenalty < 0 {
+		return fmt.Errorf("RegionPenalty must be non-negative, got %d", c.RegionPenalty)
+	}
+	if c.MutualHistoryBonus < 0 {
+		return fmt.Errorf("MutualHistoryBonus must be non-negative, got %d", c.MutualHistoryBonus)
+	}
+	if c.MutualHistoryWindow < 0 {
+		return fmt.Errorf("MutualHistoryWindow must be non-negative, got %d", c.MutualHistoryWindow)
+	}
+	if c.AudienceDiffScores != nil && len(c.AudienceDiffScores) == 0 {
+		return fmt.Errorf("AudienceDiffScores must not be empty")
+	}
+	if c.CooldownPenalty < 0 {
+		return fmt.Errorf("CooldownPenalty must be non-negative, got %d", c.CooldownPenalty)
+	}
+	if c.SoftCooldown && c.CooldownPenalty == 0 {
+		return fmt.Errorf("CooldownPenalty must be positive when SoftCooldown is set")
+	}
+	if c.BalanceMaxScore < 0 {
+		return fmt.Errorf("BalanceMaxScore must be non-negative, got %d", c.BalanceMaxScore)
+	}
+	if c.TagMaxScore < 0 {
+		return fmt.Errorf("TagMaxScore must be non-negative, got %d", c.TagMaxScore)
+	}
+	if c.FreshnessMaxScore < 0 {
+		return fmt.Errorf("FreshnessMaxScore must be non-negative, got %d", c.FreshnessMaxScore)
+	}
+	if c.ActivityMomentumBonus < 0 {
+		return fmt.Errorf("ActivityMomentumBonus must be non-negative, got %d", c.ActivityMomentumBonus)
+	}
+	if c.ActivityMomentumBonus > 0 && c.ActivityMomentumWindow <= 0 {
+		return fmt.Errorf("ActivityMomentumWindow must be positive when ActivityMomentumBonus is set, got %d", c.ActivityMomentumWindow)
+	}
+	if c.ActivityMomentumWindow < 0 {
+		return fmt.Errorf("ActivityMomentumWindow must be non-negative, got %d", c.ActivityMomentumWindow)
+	}
+	if c.FillMaxScore < 0 {
+		return fmt.Errorf("FillMaxScore must be non-negative, got %d", c.FillMaxScore)
+	}
+	if c.StickinessBonus < 0 {
+		return fmt.Errorf("StickinessBonus must be non-negative, got %d", c.StickinessBonus)
+	}
+	if c.TimeOfDayBonus < 0 {
+		return fmt.Errorf("TimeOfDayBonus must be non-negative, got %d", c.TimeOfDayBonus)
+	}
+	if c.FreshnessHalfLife < 0 {
+		return fmt.Errorf("FreshnessHalfLife must be non-negative, got %d", c.FreshnessHalfLife)
+	}
+	if c.InitiatorWeights != nil {
+		if err := c.InitiatorWeights.validate(); err != nil {
+			return fmt.Errorf("InitiatorWeights: %w", err)
+		}
+	}
+	return c.Weights.validate()
+}
+
+// 解析权重 - JSON 中未出现的字段取 DefaultScoreWeights，显式写出的0保留，由 Validate 拒绝
+func (w *ScoreWeights) UnmarshalJSON(data []byte) error {
+	type plain ScoreWeights
+	weights := plain(DefaultScoreWeights)
+	if err := json.Unmarshal(data, &weights); err != nil {
+		return err
+	}
+	*w = ScoreWeights(weights)
+	return nil
+}
+
+// 权重校验 - 每个权重必须是有限的正数
+func (w *ScoreWeights) validate() error {
+	weights := []struct {
+		name  string
+		value float64
+	}{
+		{"WaitWeight", w.WaitWeight},
+		{"SegmentWeight", w.SegmentWeight},
+		{"AudienceWeight", w.AudienceWeight},
+		{"HistoryWeight", w.HistoryWeight},
+		{"ActivityWeight", w.ActivityWeight},
+		{"LanguageWeight", w.LanguageWeight},
+	}
+	for _, weight := range weights {
+		if math.IsNaN(weight.value) || math.IsInf(weight.value, 0) {
+			return fmt.Errorf("%s must be finite, got %v", weight.name, weight.value)
+		}
+		if weight.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %v; remove the scorer from Scorers to disable it", weight.name, weight.value)
+		}
+	}
+	return nil
+}
+
+// 加载配置 - 从 JSON 读取配置，未出现的字段沿用 DefaultMatchConfig，读取后校验
+// 未知字段视为错误，避免字段名拼错被静默忽略；Scorers 和 Filters 无法从 JSON 配置
+func LoadMatchConfig(r io.Reader) (*MatchConfig, error) {
+	config := DefaultMatchConfig
+	// 解码数组时会复用已有切片的底层数组，先复制默认值避免改写 DefaultMatchConfig
+	config.AudienceDiffScores = slices.Clone(config.AudienceDiffScores)
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("decode match config: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// 配置覆盖项 - 单次调用时在基础配置的副本上修改
+// 切片和 map 字段与基础配置共享，覆盖时应整体替换而不是原地修改
+type ConfigOverride func(*MatchConfig)
+
+// 叠加覆盖项 - 返回基础配置的副本，基础配置本身不会被修改
+func (c *MatchConfig) With(overrides ...ConfigOverride) *MatchConfig {
+	copied := *c
+	for _, override := range overrides {
+		override(&copied)
+	}
+	return &copied
+}
+
+// 覆盖打分权重
+func WithWeights(weights ScoreWeights) ConfigOverride {
+	return func(c *MatchConfig) { c.Weights = weights }
+}
+
+// 冷却时间解析 - 按候选活跃度取冷却时间，未配置时回退到 RecentMatchCooldown
+func (c *MatchConfig) cooldownFor(level ActivityLevel) int64 {
+	if level < ActivityLevel(len(c.CooldownByActivity)) && c.CooldownByActivity[level] != 0 {
+		return c.CooldownByActivity[level]
+	}
+	return c.RecentMatchCooldown
+}
+
+// 观众差分值表解析 - 未配置或为空时使用默认分值表（未经 Validate 的配置也不会因空表出错）
+func (c *MatchConfig) audienceDiffScores() []int16 {
+	if len(c.AudienceDiffScores) == 0 {
+		return audienceDiffScores
+	}
+	return c.AudienceDiffScores
+}
+
+// 观众分桶 - 按 AudienceBucketSize 将观众人数映射为桶下标，未设置时返回原始人数
+func (c *MatchConfig) audienceBucket(count uint16) int {
+	if c.AudienceBucketSize <= 1 {
+		return int(count)
+	}
+	return int(count / c.AudienceBucketSize)
+}
+
+// 打分器解析 - 未配置时使用默认打分器
+func (c *MatchConfig) scorers() []Scorer {
+	if len(c.Scorers) == 0 {
+		return DefaultScorers
+	}
+	return c.Scorers
+}
+
+// 是否按软冷却处理 - 需开启 SoftCooldown 且打分器中有 CooldownScorer 负责扣分，否则冷却期内仍直接拒绝
+func (c *MatchConfig) softCooldown() bool {
+	if !c.SoftCooldown {
+		return false
+	}
+	for _, scorer := range c.scorers() {
+		if _, ok := scorer.(CooldownScorer); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否可用粘性加分 - 需设置 StickinessBonus 且打分器中有 StickinessScorer
+func (c *MatchConfig) stickiness() bool {
+	if c.StickinessBonus <= 0 {
+		return false
+	}
+	for _, scorer := range c.scorers() {
+		if _, ok := scorer.(StickinessScorer); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 按视角选择权重 - 发起方视角优先使用 InitiatorWeights
+func (c *MatchConfig) weightsFor(perspective Perspective) *ScoreWeights {
+	if perspective == PerspectiveInitiator && c.InitiatorWeights != nil {
+		return c.InitiatorWeights
+	}
+	return &c.Weights
+}
+
+// 单项权重解析 - 未经 Validate 的配置中为0的权重回退到默认权重，避免该项子得分被整体忽略
+func weightOr(value, fallback float64) float64 {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+func (w *ScoreWeights) wait() float64 {
+	return weightOr(w.WaitWeight, DefaultScoreWeights.WaitWeight)
+}
+
+func (w *ScoreWeights) segment() float64 {
+	return weightOr(w.SegmentWeight, DefaultScoreWeights.SegmentWeight)
+}
+
+func (w *ScoreWeights) audience() float64 {
+	return weightOr(w.AudienceWeight, DefaultScoreWeights.AudienceWeight)
+}
+
+func (w *ScoreWeights) history() float64 {
+	return weightOr(w.HistoryWeight, DefaultScoreWeights.HistoryWeight)
+}
+
+func (w *ScoreWeights) activity() float64 {
+	return weightOr(w.ActivityWeight, DefaultScoreWeights.ActivityWeight)
+}
+
+func (w *ScoreWeights) language() float64 {
+	return weightOr(w.LanguageWeight, DefaultScoreWeights.LanguageWeight)
+}
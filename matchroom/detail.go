@@ -0,0 +1,278 @@
+package matchroom
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// 最低分 - 被拒绝候选的 Score 固定为该值，判断是否被拒绝应使用 Rejected 标记
+const MinScore int16 = -999
+
+// 匹配详情 - 用于输出匹配原因
+type MatchDetail struct {
+	Entity             *Entity    `json:"-"`
+	Score              int16      `json:"score"`
+	RawScore           int16      `json:"raw_score"`
+	NormalizedScore    int16      `json:"normalized_score,omitempty"`
+	WaitScore          int16      `json:"wait_score"`
+	SegmentScore       int16      `json:"segment_score"`
+	AudienceScore      int16      `json:"audience_score"`
+	HistoryScore       int16      `json:"history_score"`
+	ActivityScore      int16      `json:"activity_score"`
+	ActivityMomentum   int16      `json:"activity_momentum,omitempty"` // 活跃度得分中的近期升级加成部分，已计入 ActivityScore
+	RegionScore        int16      `json:"region_score"`
+	LanguageScore      int16      `json:"language_score"`
+	CooldownScore      int16      `json:"cooldown_score"`
+	SuccessRateScore   int16      `json:"success_rate_score"`
+	MutualHistoryScore int16      `json:"mutual_history_score"`
+	BalanceScore       int16      `json:"balance_score"`
+	TagScore           int16      `json:"tag_score"`
+	FreshnessScore     int16      `json:"freshness_score"`
+	TimeOfDayScore     int16      `json:"time_of_day_score"`
+	BoostScore         int16      `json:"boost_score"`
+	StickinessScore    int16      `json:"stickiness_score"`
+	FillScore          int16      `json:"fill_score"`
+	CurrentSegment     uint8      `json:"current_segment"`
+	CandidateSegment   uint8      `json:"candidate_segment"`
+	Rejected           bool       `json:"rejected"`
+	RejectCode         RejectCode `json:"reject_code,omitempty"`
+	RejectReason       string     `json:"reject_reason,omitempty"`
+}
+
+// 拒绝码枚举 - 与 RejectReason 同时设置，便于按类别统计而不依赖文案语言
+type RejectCode uint8
+
+const (
+	RejectNone             RejectCode = iota // 未被拒绝
+	RejectBlacklisted                        // 当前用户在候选黑名单中
+	RejectBlockedByCurrent                   // 候选房主在当前实体黑名单中
+	RejectMicCountTooLow                     // 上麦人数不足
+	RejectAudienceFull                       // 观众已满
+	RejectCooldown                           // 冷却时间未满
+	RejectSegmentGap                         // 等待时间不足且段位差距过大
+	RejectSegmentMismatch                    // 段位不匹配
+	RejectCrossRegion                        // 跨地区
+	RejectFiltered                           // 被自定义过滤函数拒绝
+	RejectScorer                             // 被自定义打分器拒绝
+	RejectBelowThreshold                     // 分数低于最低分数线
+	RejectStrictSegment                      // 严格段位模式下段位不一致
+	RejectSelf                               // 候选即当前实体本身
+	RejectStaleData                          // 候选补充数据已过期
+	RejectInactive                           // 候选房间长时间无活跃，视为僵尸房间
+)
+
+var rejectCodeNames = [...]string{
+	RejectNone:             "none",
+	RejectBlacklisted:      "blacklisted",
+	RejectBlockedByCurrent: "blocked_by_current",
+	RejectMicCountTooLow:   "mic_count_too_low",
+	RejectAudienceFull:     "audience_full",
+	RejectCooldown:         "cooldown",
+	RejectSegmentGap:       "segment_gap",
+	RejectSegmentMismatch:  "segment_mismatch",
+	RejectCrossRegion:      "cross_region",
+	RejectFiltered:         "filtered",
+	RejectScorer:           "scorer",
+	RejectBelowThreshold:   "below_threshold",
+	RejectStrictSegment:    "strict_segment",
+	RejectSelf:             "self",
+	RejectStaleData:        "stale_data",
+	RejectInactive:         "inactive",
+}
+
+// 辅助函数：转换拒绝码为字符串
+func (c RejectCode) String() string {
+	if int(c) < len(rejectCodeNames) {
+		return rejectCodeNames[c]
+	}
+	return fmt.Sprintf("reject_code_%d", uint8(c))
+}
+
+// 文本序列化 - JSON 中以名称输出拒绝码
+func (c RejectCode) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// 文本反序列化
+func (c *RejectCode) UnmarshalText(text []byte) error {
+	for code, name := range rejectCodeNames {
+		if name == string(text) {
+			*c = RejectCode(code)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown reject code %q", text)
+}
+
+// 拒绝原因语言
+const (
+	LocaleZh = "zh" // 中文（默认）
+	LocaleEn = "en" // 英文
+)
+
+// 拒绝原因文案 - 拒绝码: 语言: 格式串，参数顺序与 quickReject 等调用处一致
+var rejectReasonCatalog = map[RejectCode]map[string]string{
+	RejectBlacklisted: {
+		LocaleZh: "用户在黑名单中",
+		LocaleEn: "user is blacklisted by the candidate",
+	},
+	RejectBlockedByCurrent: {
+		LocaleZh: "对方在我方黑名单中",
+		LocaleEn: "candidate is blacklisted by us",
+	},
+	RejectMicCountTooLow: {
+		LocaleZh: "上麦人数不足",
+		LocaleEn: "not enough people on mic",
+	},
+	RejectAudienceFull: {
+		LocaleZh: "观众已满",
+		LocaleEn: "audience is full",
+	},
+	RejectCooldown: {
+		LocaleZh: "冷却时间未满（%d秒前匹配过）",
+		LocaleEn: "cooldown not elapsed (matched %d seconds ago)",
+	},
+	RejectSegmentGap: {
+		LocaleZh: "等待时间不足且段位差距过大（当前段位%d，候选段位%d）",
+		LocaleEn: "wait too short for segment gap (current segment %d, candidate segment %d)",
+	},
+	RejectSegmentMismatch: {
+		LocaleZh: "段位不匹配",
+		LocaleEn: "segment mismatch",
+	},
+	RejectStrictSegment: {
+		LocaleZh: "段位不一致（严格段位模式）",
+		LocaleEn: "segment differs (strict segment mode)",
+	},
+	RejectCrossRegion: {
+		LocaleZh: "跨地区匹配（当前地区%s，候选地区%s）",
+		LocaleEn: "cross-region match (current region %s, candidate region %s)",
+	},
+	RejectFiltered: {
+		LocaleZh: "被自定义规则拒绝",
+		LocaleEn: "rejected by custom filter",
+	},
+	RejectScorer: {
+		LocaleZh: "被自定义打分器拒绝",
+		LocaleEn: "rejected by custom scorer",
+	},
+	RejectBelowThreshold: {
+		LocaleZh: "分数低于最低分数线（得分%d，最低%d）",
+		LocaleEn: "score below threshold (score %d, minimum %d)",
+	},
+	RejectSelf: {
+		LocaleZh: "自身",
+		LocaleEn: "candidate is the current entity itself",
+	},
+	RejectStaleData: {
+		LocaleZh: "数据过期",
+		LocaleEn: "candidate data is stale",
+	},
+	RejectInactive: {
+		LocaleZh: "房间不活跃",
+		LocaleEn: "candidate room is not live",
+	},
+}
+
+// 渲染拒绝原因 - 找不到对应语言时回退到中文，找不到拒绝码时返回拒绝码名称
+func ReasonText(code RejectCode, locale string, args ...any) string {
+	messages, ok := rejectReasonCatalog[code]
+	if !ok {
+		return code.String()
+	}
+	format, ok := messages[locale]
+	if !ok {
+		format = messages[LocaleZh]
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// 标记拒绝 - 同时设置拒绝码和原因，分数固定为 MinScore
+func (d *MatchDetail) reject(code RejectCode, reason string) {
+	d.Rejected = true
+	d.RejectCode = code
+	d.RejectReason = reason
+	d.Score = MinScore
+}
+
+// 详情不变量检查 - 返回第一条被违反的不变量，全部满足时返回 nil；供模糊测试和线上抽样校验使用
+// 被拒绝时 Score 固定为 MinScore 且拒绝码和原因非空；未被拒绝时 Score 和各项子得分都不能等于 MinScore，
+// 否则说明拒绝哨兵值泄漏或 int16 运算溢出
+func (d *MatchDetail) CheckInvariants() error {
+	if d.Rejected {
+		if d.Score != MinScore {
+			return fmt.Errorf("rejected detail has score %d, want %d", d.Score, MinScore)
+		}
+		if d.RejectCode == RejectNone {
+			return fmt.Errorf("rejected detail has no reject code")
+		}
+		if d.RejectReason == "" {
+			return fmt.Errorf("rejected detail (code %s) has empty reason", d.RejectCode)
+		}
+		return nil
+	}
+	if d.RejectCode != RejectNone || d.RejectReason != "" {
+		return fmt.Errorf("accepted detail carries reject code %s / reason %q", d.RejectCode, d.RejectReason)
+	}
+	if d.Score == MinScore {
+		return fmt.Errorf("accepted detail has sentinel score %d", MinScore)
+	}
+	subScores := []struct {
+		name  string
+		value int16
+	}{
+		{"WaitScore", d.WaitScore}, {"SegmentScore", d.SegmentScore}, {"AudienceScore", d.AudienceScore},
+		{"HistoryScore", d.HistoryScore}, {"ActivityScore", d.ActivityScore}, {"RegionScore", d.RegionScore},
+		{"LanguageScore", d.LanguageScore}, {"CooldownScore", d.CooldownScore}, {"SuccessRateScore", d.SuccessRateScore},
+		{"MutualHistoryScore", d.MutualHistoryScore}, {"BalanceScore", d.BalanceScore}, {"TagScore", d.TagScore},
+		{"FreshnessScore", d.FreshnessScore}, {"TimeOfDayScore", d.TimeOfDayScore},
+		{"BoostScore", d.BoostScore}, {"StickinessScore", d.StickinessScore},
+		{"FillScore", d.FillScore},
+	}
+	for _, sub := range subScores {
+		if sub.value == MinScore {
+			return fmt.Errorf("accepted detail has sentinel %s %d", sub.name, MinScore)
+		}
+	}
+	return nil
+}
+
+// 重置详情 - 清空所有字段，复用前调用
+func (d *MatchDetail) Reset() {
+	*d = MatchDetail{}
+}
+
+// 复制详情 - 需要在 DetailBuffer 下次匹配后继续保留某个详情时使用；Entity 仍指向同一实体
+func (d *MatchDetail) Copy() *MatchDetail {
+	c := *d
+	return &c
+}
+
+// 详情对应的实体 - 详情为空时返回 nil
+func (d *MatchDetail) entity() *Entity {
+	if d == nil {
+		return nil
+	}
+	return d.Entity
+}
+
+// MatchDetail 的 JSON 辅助类型 - 避免 MarshalJSON 递归
+type matchDetailAlias MatchDetail
+
+// JSON序列化 - 以候选ID代替完整实体，避免日志中重复输出实体数据
+func (d *MatchDetail) MarshalJSON() ([]byte, error) {
+	entityID := ""
+	if d.Entity != nil {
+		entityID = d.Entity.ID
+	}
+	return json.Marshal(&struct {
+		EntityID string `json:"entity_id"`
+		*matchDetailAlias
+	}{
+		EntityID:         entityID,
+		matchDetailAlias: (*matchDetailAlias)(d),
+	})
+}
@@ -0,0 +1,300 @@
+package matchroom
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 匹配引擎 - 持有匹配配置与随机源，便于在服务中内嵌使用
+// 配置保存在原子指针中，可通过 SetConfig 热更新；每次匹配开始时读取一次快照，进行中的匹配不受更新影响
+// 配置交给引擎后调用方不得再修改，需要改动时应复制一份修改后再 SetConfig
+type MatchEngine struct {
+	config  atomic.Pointer[MatchConfig]
+	rng     *rand.Rand
+	mu      sync.Mutex  // 保护 rng，*rand.Rand 非并发安全
+	rejects rejectCache // 近期被拒绝的 (配置, 当前实体, 用户, 候选) 组合，RejectCacheTTL 大于0时启用，SetConfig 时清空
+}
+
+// 拒绝缓存的默认容量
+const defaultRejectCacheSize = 10000
+
+// 拒绝缓存键 - 含配置快照指针，不同配置下的拒绝结果互不沿用
+type rejectKey struct {
+	config                   *MatchConfig
+	current, user, candidate string
+}
+
+// 拒绝缓存项 - 到 expires 时间戳（不含）为止有效，且双方上麦人数须与记录时相同
+type rejectEntry struct {
+	code         RejectCode
+	reason       string
+	rawScore     int16
+	expires      int64
+	currentMic   uint16 // 记录时当前实体的上麦人数，段位和上麦人数相关的拒绝随之变化
+	candidateMic uint16 // 记录时候选的上麦人数
+}
+
+// 拒绝缓存 - 按加入顺序淘汰，容量满时先淘汰过期项再淘汰最早加入的项；并发安全
+type rejectCache struct {
+	mu      sync.Mutex
+	entries map[rejectKey]rejectEntry
+	order   []rejectQueued // 加入顺序，键被重新加入后旧的记录按 expires 不一致识别并丢弃
+}
+
+type rejectQueued struct {
+	key     rejectKey
+	expires int64
+}
+
+// 查询 - 拒绝记录未过期时返回
+func (c *rejectCache) lookup(key rejectKey, now int64) (rejectEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || now >= entry.expires {
+		return rejectEntry{}, false
+	}
+	return entry, true
+}
+
+// 清空 - 配置更新后旧配置下的拒绝结果不再有效
+func (c *rejectCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+	c.order = nil
+}
+
+// 拒绝缓存到期时间 - 拒绝原因会随时间或等待时长自然消失时，不超过其消失的时刻；ok 为 false 表示不应缓存
+// 段位拒绝缓存到候选等待时长按时间增长到门槛的时刻；段位和上麦人数相关的拒绝由记录的上麦人数校验，
+// 房间上麦人数变化后不再沿用。观众已满、数据过期、不活跃随房间数据刷新随时可能解除，
+// 分数线取决于全部子得分，自定义过滤和打分器可能依赖任意输入，均不缓存
+func rejectExpiry(detail *MatchDetail, current *Entity, userID string, config *MatchConfig, now int64) (expires int64, ok bool) {
+	expires = now + config.RejectCacheTTL
+	candidate := detail.Entity
+	switch detail.RejectCode {
+	case RejectAudienceFull, RejectStaleData, RejectInactive, RejectBelowThreshold, RejectFiltered, RejectScorer:
+		return 0, false
+	case RejectSegmentGap, RejectSegmentMismatch:
+		if threshold, passable := segmentWaitThreshold(detail.CurrentSegment, detail.CandidateSegment, config); passable {
+			expires = min(expires, now+int64(threshold)-int64(candidate.WaitSeconds))
+		}
+	case RejectCooldown:
+		if lastTime, found := candidate.LastMatchedUsers[userID]; found {
+			expires = min(expires, lastTime+config.cooldownFor(candidate.ActivityLevel))
+		}
+	case RejectBlacklisted:
+		if until, found := candidate.TempBlocks[userID]; found {
+			expires = min(expires, until)
+		}
+	case RejectBlockedByCurrent:
+		if until, found := current.TempBlocks[candidate.Owner()]; found {
+			expires = min(expires, until)
+		}
+	}
+	return expires, expires > now
+}
+
+// 段位等待门槛 - 候选等待不少于该秒数时段位规则不再拒绝；passable 为 false 表示该段位距离等待多久都会被拒绝
+func segmentWaitThreshold(currentSeg, candidateSeg uint8, config *MatchConfig) (threshold uint16, passable bool) {
+	table := config.SegmentDistanceScores
+	if table == nil {
+		return 60, true
+	}
+	distance := segmentDistance(currentSeg, candidateSeg)
+	if int(distance) >= len(table) {
+		return 0, false
+	}
+	return table[distance].MinWait, true
+}
+
+// 记录 - 容量不超过 size
+func (c *rejectCache) store(key rejectKey, entry rejectEntry, now int64, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[rejectKey]rejectEntry)
+	}
+	for len(c.order) > 0 {
+		front := c.order[0]
+		current, ok := c.entries[front.key]
+		stale := !ok || current.expires != front.expires
+		if !stale && current.expires > now && len(c.entries) < size {
+			break
+		}
+		c.order = c.order[1:]
+		if !stale {
+			delete(c.entries, front.key)
+		}
+	}
+	c.entries[key] = entry
+	c.order = append(c.order, rejectQueued{key: key, expires: entry.expires})
+}
+
+// 匹配核心 - 开启 RejectCacheTTL 时跳过近期被拒绝过的候选，直接沿用缓存的拒绝原因
+// 只有引擎当前配置下的匹配读写缓存，覆盖配置的单次调用照常完整打分
+func (e *MatchEngine) matchDetailed(current *Entity, pool []*Entity, userID string, config *MatchConfig, now int64) (*MatchDetail, []*MatchDetail) {
+	if config.RejectCacheTTL <= 0 || config != e.Config() {
+		return matchDetailedWith(current, pool, userID, config, now, e.intn)
+	}
+
+	size := config.RejectCacheSize
+	if size <= 0 {
+		size = defaultRejectCacheSize
+	}
+	currentSeg := config.segmentOf(current)
+	opts := scoreOptions{
+		cached: func(candidate *Entity) *MatchDetail {
+			entry, ok := e.rejects.lookup(rejectKey{config, current.ID, userID, candidate.ID}, now)
+			if !ok || entry.currentMic != current.MicCount || entry.candidateMic != candidate.MicCount {
+				return nil
+			}
+			detail := &MatchDetail{
+				Entity:           candidate,
+				CurrentSegment:   currentSeg,
+				CandidateSegment: config.segmentOf(candidate),
+				RawScore:         entry.rawScore,
+			}
+			detail.reject(entry.code, entry.reason)
+			return detail
+		},
+		scored: func(detail *MatchDetail) {
+			if !detail.Rejected {
+				return
+			}
+			expires, ok := rejectExpiry(detail, current, userID, config, now)
+			if !ok {
+				return
+			}
+			entry := rejectEntry{
+				code:         detail.RejectCode,
+				reason:       detail.RejectReason,
+				rawScore:     detail.RawScore,
+				expires:      expires,
+				currentMic:   current.MicCount,
+				candidateMic: detail.Entity.MicCount,
+			}
+			e.rejects.store(rejectKey{config, current.ID, userID, detail.Entity.ID}, entry, now, size)
+		},
+	}
+	details, _ := scorePoolWith(context.Background(), current, pool, userID, config, now, opts)
+	return selectBest(details, config, e.intn), details
+}
+
+// 创建匹配引擎 - config 为空时使用默认配置，并列最高分时使用全局随机源
+func NewMatchEngine(config *MatchConfig) (*MatchEngine, error) {
+	return NewMatchEngineWithRand(config, nil)
+}
+
+// 创建使用指定随机源的匹配引擎 - 相同种子的引擎在并列时选出相同候选，便于复现
+// 配置不合法时直接返回错误，避免产生错误的分数
+func NewMatchEngineWithRand(config *MatchConfig, rng *rand.Rand) (*MatchEngine, error) {
+	if config == nil {
+		config = &DefaultMatchConfig
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	e := &MatchEngine{rng: rng}
+	e.config.Store(config)
+	return e, nil
+}
+
+// 引擎配置 - 返回当前配置快照，不得修改
+func (e *MatchEngine) Config() *MatchConfig {
+	return e.config.Load()
+}
+
+// 热更新配置 - 校验通过后原子替换并清空拒绝缓存，config 为空时使用默认配置；校验失败时保留原配置
+func (e *MatchEngine) SetConfig(config *MatchConfig) error {
+	if config == nil {
+		config = &DefaultMatchConfig
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	e.config.Store(config)
+	e.rejects.reset()
+	return nil
+}
+
+// 并发安全的随机数 - 未指定随机源时走全局 rand
+func (e *MatchEngine) intn(n int) int {
+	if e.rng == nil {
+		return rand.Intn(n)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.rng.Intn(n)
+}
+
+// 匹配 - 返回选中的实体
+func (e *MatchEngine) Match(current *Entity, pool []*Entity, userID string) *Entity {
+	matched, _ := e.MatchDetailed(current, pool, userID)
+	return matched
+}
+
+// 详细匹配 - 返回选中的实体及所有候选的打分详情
+func (e *MatchEngine) MatchDetailed(current *Entity, pool []*Entity, userID string) (*Entity, []*MatchDetail) {
+	selected, details := e.matchDetailed(current, pool, userID, e.Config(), time.Now().Unix())
+	return selected.entity(), details
+}
+
+// 指定时间匹配 - 以 now 作为当前时间
+func (e *MatchEngine) MatchAt(current *Entity, pool []*Entity, userID string, now int64) (*Entity, []*MatchDetail) {
+	selected, details := e.matchDetailed(current, pool, userID, e.Config(), now)
+	return selected.entity(), details
+}
+
+// 覆盖配置匹配 - 本次调用使用叠加了 overrides 的配置副本，引擎配置不会被修改，可并发调用
+func (e *MatchEngine) MatchDetailedWithOverrides(current *Entity, pool []*Entity, userID string, overrides ...ConfigOverride) (*Entity, []*MatchDetail, error) {
+	config := e.Config().With(overrides...)
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+	selected, details := e.matchDetailed(current, pool, userID, config, time.Now().Unix())
+	return selected.entity(), details, nil
+}
+
+// 完整匹配 - 返回选中实体、详情及统计
+func (e *MatchEngine) MatchFull(current *Entity, pool []*Entity, userID string) *MatchOutcome {
+	config := e.Config()
+	selected, details := e.matchDetailed(current, pool, userID, config, time.Now().Unix())
+	return newMatchOutcome(selected, details, config)
+}
+
+// 带分数匹配 - 返回选中的实体及其打分详情
+func (e *MatchEngine) MatchWithScore(current *Entity, pool []*Entity, userID string) (*Entity, *MatchDetail) {
+	selected, _ := e.matchDetailed(current, pool, userID, e.Config(), time.Now().Unix())
+	return selected.entity(), selected
+}
+
+// 批量匹配 - 使用引擎当前配置为每个房间匹配，rooms 与 userIDs 一一对应
+func (e *MatchEngine) BatchMatch(rooms []*Entity, pool []*Entity, userIDs []string) map[string]*Entity {
+	config := e.Config()
+	return batchMatchEntities(rooms, pool, userIDs, config, e.roomMatcher(config))
+}
+
+// 批量详细匹配 - 返回每个房间的完整匹配结果
+func (e *MatchEngine) BatchMatchDetailed(rooms []*Entity, pool []*Entity, userIDs []string) map[string]*MatchOutcome {
+	config := e.Config()
+	return batchMatchEntitiesDetailed(rooms, pool, userIDs, config, e.roomMatcher(config))
+}
+
+// 并发批量匹配 - 按 concurrency 个 worker 并发匹配，并列时使用引擎的随机源
+// 各协程取随机数的先后顺序不确定，即使引擎使用相同种子，并列时的选择也可能不同
+func (e *MatchEngine) BatchMatchConcurrent(rooms []*Entity, pool []*Entity, userIDs []string, concurrency int) map[string]*Entity {
+	config := e.Config()
+	return batchMatchEntitiesConcurrent(rooms, pool, userIDs, config, concurrency, e.roomMatcher(config))
+}
+
+// 单房间匹配函数 - 批量匹配时使用同一配置快照和当前时间，经由引擎的随机源和拒绝缓存
+func (e *MatchEngine) roomMatcher(config *MatchConfig) roomMatchFunc {
+	now := time.Now().Unix()
+	return func(room *Entity, pool []*Entity, userID string) (*MatchDetail, []*MatchDetail) {
+		return e.matchDetailed(room, pool, userID, config, now)
+	}
+}
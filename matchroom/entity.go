@@ -0,0 +1,477 @@
+package matchroom
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+)
+
+// 活跃度等级枚举 - 使用 uint8 节省内存
+type ActivityLevel uint8
+
+const (
+	ActivityLow ActivityLevel = iota
+	ActivityMedium
+	ActivityHigh
+)
+
+// 信息结构体 - 优化数据类型对齐
+// map 字段均可为 nil：读取时按空处理，包内的写入路径（BlockUser、RecordMatch、构造选项等）会先初始化
+type Entity struct {
+	ID                string              `json:"id"`                             // ID
+	OwnerID           string              `json:"owner_id,omitempty"`             // 房主用户ID，为空时使用 ID
+	Region            string              `json:"region,omitempty"`               // 所在地区/机房，为空表示未知
+	Language          string              `json:"language,omitempty"`             // 使用语言，为空表示未知
+	LastMatchedUsers  map[string]int64    `json:"last_matched_users"`             // 用户ID: 时间戳
+	Blacklist         map[string]struct{} `json:"-"`                              // 黑名单，使用struct{}节省内存，JSON中以数组表示
+	TempBlocks        map[string]int64    `json:"temp_blocks,omitempty"`          // 临时屏蔽，用户ID: 到期时间戳
+	MicCount          uint16              `json:"mic_count"`                      // 上麦人数
+	AudienceCount     uint16              `json:"audience_count"`                 // 观众人数
+	WaitSeconds       uint16              `json:"wait_seconds"`                   // 等待时间（秒）
+	MatchHistory      uint16              `json:"match_history"`                  // 历史成功匹配次数
+	MatchAttempts     uint16              `json:"match_attempts,omitempty"`       // 历史匹配尝试次数，用于计算成功率
+	ActivityLevel     ActivityLevel       `json:"activity_level"`                 // 活跃度等级
+	Roles             map[string]uint16   `json:"roles,omitempty"`                // 各角色人数，角色: 人数，用于角色平衡打分
+	Tags              []string            `json:"tags,omitempty"`                 // 兴趣标签，如 music、gaming
+	LastActiveUnix    int64               `json:"last_active_unix,omitempty"`     // 最近活跃时间戳，为0表示未知
+	DataFetchedAtUnix int64               `json:"data_fetched_at_unix,omitempty"` // 补充数据的拉取时间戳，超过 DataTTL 视为过期
+	ActivitySinceUnix int64               `json:"activity_since_unix,omitempty"`  // 进入当前活跃度等级的时间戳，为0表示未知
+	PreferredHours    uint32              `json:"preferred_hours,omitempty"`      // 偏好时段位图，第 h 位表示 h 点，为0表示无偏好
+	AllowListMode     bool                `json:"allow_list_mode,omitempty"`      // 为 true 时 Blacklist 按白名单解释：只有其中的用户不被屏蔽，空表屏蔽所有人
+	BoostAmount       int16               `json:"boost_amount,omitempty"`         // 运营推广加分，在 BoostUntilUnix 之前作为候选时固定加分
+	BoostUntilUnix    int64               `json:"boost_until_unix,omitempty"`     // 推广到期时间戳（不含），到期后加分立即失效
+	segment           uint8               // 缓存的段位，由 SetMicCount/RefreshSegment 写入
+	segmentMic        uint16              // 计算缓存段位时的上麦人数，用于发现 MicCount 被直接修改
+	segmentCached     bool                // 段位缓存是否已写入
+}
+
+// 房主用户ID - 未设置 OwnerID 时以实体ID代替
+func (e *Entity) Owner() string {
+	if e.OwnerID != "" {
+		return e.OwnerID
+	}
+	return e.ID
+}
+
+// 是否存活 - 距最近活跃不超过 timeout 秒（恰好等于时仍存活）；活跃时间未知时视为不存活
+func (e *Entity) IsLive(now, timeout int64) bool {
+	return e.LastActiveUnix != 0 && now-e.LastActiveUnix <= timeout
+}
+
+// 临时屏蔽用户 - 到 until 时间戳（不含）为止视为黑名单
+func (e *Entity) BlockUser(userID string, until int64) {
+	if e.TempBlocks == nil {
+		e.TempBlocks = make(map[string]int64)
+	}
+	e.TempBlocks[userID] = until
+}
+
+// 是否屏蔽用户 - 永久黑名单或未到期的临时屏蔽
+// AllowListMode 下 Blacklist 为白名单，不在其中的用户一律屏蔽，临时屏蔽对白名单用户同样生效
+func (e *Entity) IsBlocked(userID string, now int64) bool {
+	return e.blacklisted(userID) || e.tempBlocked(userID, now)
+}
+
+// 是否被永久黑名单屏蔽 - AllowListMode 下不在白名单中即屏蔽
+func (e *Entity) blacklisted(userID string) bool {
+	_, exists := e.Blacklist[userID]
+	return exists != e.AllowListMode
+}
+
+// 是否被未到期的临时屏蔽
+func (e *Entity) tempBlocked(userID string, now int64) bool {
+	until, ok := e.TempBlocks[userID]
+	return ok && now < until
+}
+
+// 清理匹配记录 - 删除已过冷却期（距 now 不少于 cooldown 秒）的记录，返回删除条数
+func (e *Entity) PruneMatchHistory(now int64, cooldown int64) int {
+	removed := 0
+	for userID, lastTime := range e.LastMatchedUsers {
+		if now-lastTime >= cooldown {
+			delete(e.LastMatchedUsers, userID)
+			removed++
+		}
+	}
+	return removed
+}
+
+// 记录匹配结果 - 双方互相写入对方用户的匹配时间并各自增加一次成功匹配次数和尝试次数
+// 之后双方再次匹配时会受冷却时间限制；LastMatchedUsers 为 nil 时自动初始化
+func RecordMatch(a, b *Entity, userA, userB string, now int64) {
+	a.recordMatchWith(userB, now)
+	b.recordMatchWith(userA, now)
+}
+
+func (e *Entity) recordMatchWith(userID string, now int64) {
+	if e.LastMatchedUsers == nil {
+		e.LastMatchedUsers = make(map[string]int64)
+	}
+	e.LastMatchedUsers[userID] = now
+	if e.MatchHistory < math.MaxUint16 {
+		e.MatchHistory++
+	}
+	if e.MatchAttempts < math.MaxUint16 {
+		e.MatchAttempts++
+	}
+}
+
+// 深拷贝 - 复制所有 map，修改副本不会影响原实体
+func (e *Entity) Clone() *Entity {
+	c := *e
+	c.LastMatchedUsers = maps.Clone(e.LastMatchedUsers)
+	c.Blacklist = maps.Clone(e.Blacklist)
+	c.TempBlocks = maps.Clone(e.TempBlocks)
+	c.Roles = maps.Clone(e.Roles)
+	c.Tags = slices.Clone(e.Tags)
+	return &c
+}
+
+// 实体是否相等 - 比较所有导出字段，map 按集合内容比较（nil 与空 map 视为相等），忽略段位缓存
+func (e *Entity) Equal(other *Entity) bool {
+	if e == nil || other == nil {
+		return e == other
+	}
+	return len(e.Diff(other)) == 0
+}
+
+// 实体差异 - 返回取值不同的字段名（按结构体字段顺序），相等时返回 nil；用于测试和在线池的变更检测
+func (e *Entity) Diff(other *Entity) []string {
+	if e == nil || other == nil {
+		if e == other {
+			return nil
+		}
+		return []string{"Entity"}
+	}
+	var diff []string
+	check := func(name string, equal bool) {
+		if !equal {
+			diff = append(diff, name)
+		}
+	}
+	check("ID", e.ID == other.ID)
+	check("OwnerID", e.OwnerID == other.OwnerID)
+	check("Region", e.Region == other.Region)
+	check("Language", e.Language == other.Language)
+	check("LastMatchedUsers", maps.Equal(e.LastMatchedUsers, other.LastMatchedUsers))
+	check("Blacklist", maps.Equal(e.Blacklist, other.Blacklist))
+	check("TempBlocks", maps.Equal(e.TempBlocks, other.TempBlocks))
+	check("MicCount", e.MicCount == other.MicCount)
+	check("AudienceCount", e.AudienceCount == other.AudienceCount)
+	check("WaitSeconds", e.WaitSeconds == other.WaitSeconds)
+	check("MatchHistory", e.MatchHistory == other.MatchHistory)
+	check("MatchAttempts", e.MatchAttempts == other.MatchAttempts)
+	check("ActivityLevel", e.ActivityLevel == other.ActivityLevel)
+	check("Roles", maps.Equal(e.Roles, other.Roles))
+	check("Tags", slices.Equal(e.Tags, other.Tags))
+	check("LastActiveUnix", e.LastActiveUnix == other.LastActiveUnix)
+	check("DataFetchedAtUnix", e.DataFetchedAtUnix == other.DataFetchedAtUnix)
+	check("ActivitySinceUnix", e.ActivitySinceUnix == other.ActivitySinceUnix)
+	check("PreferredHours", e.PreferredHours == other.PreferredHours)
+	check("AllowListMode", e.AllowListMode == other.AllowListMode)
+	check("BoostAmount", e.BoostAmount == other.BoostAmount)
+	check("BoostUntilUnix", e.BoostUntilUnix == other.BoostUntilUnix)
+	return diff
+}
+
+// 实体校验 - 检查外部数据构造的实体是否存在不可能的取值，供入池前拒绝异常房间
+func (e *Entity) Validate() error {
+	if e.ID == "" {
+		return fmt.Errorf("entity ID must not be empty")
+	}
+	if e.ActivityLevel > ActivityHigh {
+		return fmt.Errorf("entity %s: unknown activity level %d", e.ID, e.ActivityLevel)
+	}
+	if e.MatchAttempts > 0 && e.MatchHistory > e.MatchAttempts {
+		return fmt.Errorf("entity %s: match history %d exceeds match attempts %d", e.ID, e.MatchHistory, e.MatchAttempts)
+	}
+	if e.PreferredHours>>24 != 0 {
+		return fmt.Errorf("entity %s: preferred hours %#x has bits beyond hour 23", e.ID, e.PreferredHours)
+	}
+	if e.BoostAmount < 0 {
+		return fmt.Errorf("entity %s: boost amount must be non-negative, got %d", e.ID, e.BoostAmount)
+	}
+	// 直接修改 MicCount 只会让缓存失效，Segment 会重新计算；只有仍被使用的缓存与上麦人数不符才是错误
+	if e.segmentCached && e.segmentMic == e.MicCount {
+		if want := getMicSegment(e.MicCount); e.segment != want {
+			return fmt.Errorf("entity %s: cached segment %d, want %d", e.ID, e.segment, want)
+		}
+	}
+	return nil
+}
+
+// 段位 - 优先使用缓存；MicCount 被直接修改导致缓存失效时重新计算
+// 读取时不写缓存，候选池可被多个协程并发读取
+func (e *Entity) Segment() uint8 {
+	if e.segmentCached && e.segmentMic == e.MicCount {
+		return e.segment
+	}
+	return getMicSegment(e.MicCount)
+}
+
+// 设置上麦人数 - 同时刷新段位缓存
+func (e *Entity) SetMicCount(count uint16) {
+	e.MicCount = count
+	e.RefreshSegment()
+}
+
+// 刷新段位缓存 - 候选池刷新或直接修改 MicCount 后调用
+func (e *Entity) RefreshSegment() {
+	e.segment = getMicSegment(e.MicCount)
+	e.segmentMic = e.MicCount
+	e.segmentCached = true
+}
+
+// 实体构造选项
+type EntityOption func(*Entity)
+
+// 创建实体 - 总是初始化 LastMatchedUsers 和 Blacklist，避免写入 nil map
+func NewEntity(id string, opts ...EntityOption) *Entity {
+	e := &Entity{
+		ID:               id,
+		LastMatchedUsers: make(map[string]int64),
+		Blacklist:        make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.RefreshSegment()
+	return e
+}
+
+// 房主用户ID
+func WithOwnerID(ownerID string) EntityOption {
+	return func(e *Entity) { e.OwnerID = ownerID }
+}
+
+// 上麦人数
+func WithMicCount(count uint16) EntityOption {
+	return func(e *Entity) { e.MicCount = count }
+}
+
+// 观众人数
+func WithAudienceCount(count uint16) EntityOption {
+	return func(e *Entity) { e.AudienceCount = count }
+}
+
+// 等待时间（秒）
+func WithWaitSeconds(seconds uint16) EntityOption {
+	return func(e *Entity) { e.WaitSeconds = seconds }
+}
+
+// 历史成功匹配次数
+func WithMatchHistory(history uint16) EntityOption {
+	return func(e *Entity) { e.MatchHistory = history }
+}
+
+// 所在地区
+func WithRegion(region string) EntityOption {
+	return func(e *Entity) { e.Region = region }
+}
+
+// 使用语言
+func WithLanguage(language string) EntityOption {
+	return func(e *Entity) { e.Language = language }
+}
+
+// 历史匹配尝试次数
+func WithMatchAttempts(attempts uint16) EntityOption {
+	return func(e *Entity) { e.MatchAttempts = attempts }
+}
+
+// 活跃度等级
+func WithActivity(level ActivityLevel) EntityOption {
+	return func(e *Entity) { e.ActivityLevel = level }
+}
+
+// 白名单用户 - 开启 AllowListMode，userIDs 写入 Blacklist 作为白名单；不传用户时屏蔽所有人
+// 与 WithBlacklist 共用同一个 map，不要同时使用
+func WithAllowList(userIDs ...string) EntityOption {
+	return func(e *Entity) {
+		e.AllowListMode = true
+		WithBlacklist(userIDs...)(e)
+	}
+}
+
+// 黑名单用户
+func WithBlacklist(userIDs ...string) EntityOption {
+	return func(e *Entity) {
+		if e.Blacklist == nil {
+			e.Blacklist = make(map[string]struct{}, len(userIDs))
+		}
+		for _, userID := range userIDs {
+			e.Blacklist[userID] = struct{}{}
+		}
+	}
+}
+
+// 最近匹配过的用户及匹配时间戳
+func WithLastMatched(userID string, at int64) EntityOption {
+	return func(e *Entity) {
+		if e.LastMatchedUsers == nil {
+			e.LastMatchedUsers = make(map[string]int64)
+		}
+		e.LastMatchedUsers[userID] = at
+	}
+}
+
+// 角色人数
+func WithRole(role string, count uint16) EntityOption {
+	return func(e *Entity) {
+		if e.Roles == nil {
+			e.Roles = make(map[string]uint16)
+		}
+		e.Roles[role] = count
+	}
+}
+
+// 最近活跃时间
+func WithLastActive(at int64) EntityOption {
+	return func(e *Entity) { e.LastActiveUnix = at }
+}
+
+// 活跃度等级及进入该等级的时间
+func WithActivitySince(level ActivityLevel, since int64) EntityOption {
+	return func(e *Entity) {
+		e.ActivityLevel = level
+		e.ActivitySinceUnix = since
+	}
+}
+
+// 补充数据拉取时间
+func WithDataFetchedAt(at int64) EntityOption {
+	return func(e *Entity) { e.DataFetchedAtUnix = at }
+}
+
+// 偏好时段 - 0-23 点，超出范围的忽略
+func WithPreferredHours(hours ...int) EntityOption {
+	return func(e *Entity) {
+		for _, hour := range hours {
+			if hour >= 0 && hour < 24 {
+				e.PreferredHours |= 1 << hour
+			}
+		}
+	}
+}
+
+// 推广加分 - 到 until 时间戳（不含）为止作为候选时加 amount 分
+func WithBoost(amount int16, until int64) EntityOption {
+	return func(e *Entity) {
+		e.BoostAmount = amount
+		e.BoostUntilUnix = until
+	}
+}
+
+// 兴趣标签
+func WithTags(tags ...string) EntityOption {
+	return func(e *Entity) { e.Tags = append(e.Tags, tags...) }
+}
+
+// 辅助函数：创建活跃度枚举
+func ParseActivityLevel(level string) ActivityLevel {
+	switch level {
+	case "high":
+		return ActivityHigh
+	case "medium":
+		return ActivityMedium
+	default:
+		return ActivityLow
+	}
+}
+
+// 辅助函数：严格解析活跃度，未知取值返回错误，用于校验接口输入
+func ParseActivityLevelStrict(level string) (ActivityLevel, error) {
+	switch level {
+	case "high":
+		return ActivityHigh, nil
+	case "medium":
+		return ActivityMedium, nil
+	case "low":
+		return ActivityLow, nil
+	default:
+		return ActivityLow, fmt.Errorf("unknown activity level %q", level)
+	}
+}
+
+// 辅助函数：转换活跃度为字符串
+func (a ActivityLevel) String() string {
+	switch a {
+	case ActivityHigh:
+		return "high"
+	case ActivityMedium:
+		return "medium"
+	default:
+		return "low"
+	}
+}
+
+// JSON序列化 - 活跃度输出为 "low"/"medium"/"high"
+func (a ActivityLevel) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// JSON反序列化 - 接受字符串形式，兼容旧的数字形式；未知字符串和超出范围的数字返回错误
+func (a *ActivityLevel) UnmarshalJSON(data []byte) error {
+	var level string
+	if err := json.Unmarshal(data, &level); err == nil {
+		parsed, err := ParseActivityLevelStrict(level)
+		if err != nil {
+			return err
+		}
+		*a = parsed
+		return nil
+	}
+	var raw uint8
+	if err := json.Unmarshal(data, &raw); err != nil || ActivityLevel(raw) > ActivityHigh {
+		return fmt.Errorf("invalid activity level %s", data)
+	}
+	*a = ActivityLevel(raw)
+	return nil
+}
+
+// Entity 的 JSON 辅助类型 - 避免 MarshalJSON 递归
+type entityAlias Entity
+
+// JSON序列化 - 黑名单输出为排序后的用户ID数组，保证格式稳定
+// 使用值接收者，Entity 值和 []Entity 同样走自定义序列化，不会丢失黑名单
+func (e Entity) MarshalJSON() ([]byte, error) {
+	blacklist := make([]string, 0, len(e.Blacklist))
+	for userID := range e.Blacklist {
+		blacklist = append(blacklist, userID)
+	}
+	sort.Strings(blacklist)
+
+	return json.Marshal(&struct {
+		*entityAlias
+		Blacklist []string `json:"blacklist"`
+	}{
+		entityAlias: (*entityAlias)(&e),
+		Blacklist:   blacklist,
+	})
+}
+
+// JSON反序列化 - 总是初始化两个 map，避免后续写入 nil map
+func (e *Entity) UnmarshalJSON(data []byte) error {
+	aux := &struct {
+		*entityAlias
+		Blacklist []string `json:"blacklist"`
+	}{
+		entityAlias: (*entityAlias)(e),
+	}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+
+	if e.LastMatchedUsers == nil {
+		e.LastMatchedUsers = make(map[string]int64)
+	}
+	e.Blacklist = make(map[string]struct{}, len(aux.Blacklist))
+	for _, userID := range aux.Blacklist {
+		e.Blacklist[userID] = struct{}{}
+	}
+	e.RefreshSegment()
+	return nil
+}
@@ -0,0 +1,123 @@
+package matchroom
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// CSV 表头 - 与 detailCSVRow 的列一一对应
+var detailCSVHeader = []string{
+	"current_id", "entity_id", "score", "raw_score", "normalized_score",
+	"wait_score", "segment_score", "audience_score", "history_score", "activity_score",
+	"region_score", "language_score", "cooldown_score", "success_rate_score",
+	"mutual_history_score", "balance_score", "tag_score", "freshness_score", "time_of_day_score", "boost_score", "stickiness_score", "fill_score",
+	"current_segment", "candidate_segment", "rejected", "reject_code", "reject_reason",
+}
+
+// 导出 CSV - 每个候选一行，含各项子得分、拒绝标记和拒绝码，供表格分析
+func WriteDetailsCSV(w io.Writer, current *Entity, details []*MatchDetail) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(detailCSVHeader); err != nil {
+		return err
+	}
+	for _, detail := range details {
+		if err := cw.Write(detailCSVRow(current, detail)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func detailCSVRow(current *Entity, d *MatchDetail) []string {
+	entityID := ""
+	if d.Entity != nil {
+		entityID = d.Entity.ID
+	}
+	scores := []int16{
+		d.Score, d.RawScore, d.NormalizedScore,
+		d.WaitScore, d.SegmentScore, d.AudienceScore, d.HistoryScore, d.ActivityScore,
+		d.RegionScore, d.LanguageScore, d.CooldownScore, d.SuccessRateScore,
+		d.MutualHistoryScore, d.BalanceScore, d.TagScore, d.FreshnessScore, d.TimeOfDayScore, d.BoostScore, d.StickinessScore, d.FillScore,
+	}
+	row := make([]string, 0, len(detailCSVHeader))
+	row = append(row, current.ID, entityID)
+	for _, score := range scores {
+		row = append(row, strconv.Itoa(int(score)))
+	}
+	return append(row,
+		strconv.Itoa(int(d.CurrentSegment)),
+		strconv.Itoa(int(d.CandidateSegment)),
+		strconv.FormatBool(d.Rejected),
+		d.RejectCode.String(),
+		d.RejectReason,
+	)
+}
+
+// 输出匹配详情 - 输出到标准输出
+func PrintMatchDetails(current *Entity, matched *Entity, details []*MatchDetail) {
+	WriteMatchDetails(os.Stdout, current, matched, details)
+}
+
+// 输出匹配详情 - 输出到指定的 io.Writer，便于重定向到缓冲区或日志
+func WriteMatchDetails(w io.Writer, current *Entity, matched *Entity, details []*MatchDetail) {
+	fmt.Fprintf(w, "\n=== 匹配详情 ===\n")
+	fmt.Fprintf(w, "当前实体: %s (麦位:%d, 观众:%d, 等待:%d秒, 段位:%d)\n",
+		current.ID, current.MicCount, current.AudienceCount, current.WaitSeconds, current.Segment())
+
+	if matched != nil {
+		fmt.Fprintf(w, "✅ 匹配成功: %s\n", matched.ID)
+
+		// 找到匹配的实体详情
+		for _, detail := range details {
+			if detail.Entity.ID == matched.ID {
+				fmt.Fprintf(w, "匹配原因:\n")
+				fmt.Fprintf(w, "  - 等待时间得分: %d (等待%d秒)\n", detail.WaitScore, detail.Entity.WaitSeconds)
+				fmt.Fprintf(w, "  - 段位得分: %d (当前段位%d, 候选段位%d)\n", detail.SegmentScore, detail.CurrentSegment, detail.CandidateSegment)
+				fmt.Fprintf(w, "  - 观众差异得分: %d (观众差%d)\n", detail.AudienceScore, int(current.AudienceCount)-int(detail.Entity.AudienceCount))
+				fmt.Fprintf(w, "  - 历史得分: %d (历史匹配%d次)\n", detail.HistoryScore, detail.Entity.MatchHistory)
+				fmt.Fprintf(w, "  - 成功率得分: %d (成功%d次/尝试%d次)\n", detail.SuccessRateScore, detail.Entity.MatchHistory, detail.Entity.MatchAttempts)
+				if detail.ActivityMomentum != 0 {
+					fmt.Fprintf(w, "  - 活跃度得分: %d (%s, 近期升级加成%d)\n", detail.ActivityScore, detail.Entity.ActivityLevel.String(), detail.ActivityMomentum)
+				} else {
+					fmt.Fprintf(w, "  - 活跃度得分: %d (%s)\n", detail.ActivityScore, detail.Entity.ActivityLevel.String())
+				}
+				fmt.Fprintf(w, "  - 地区得分: %d (当前地区%s, 候选地区%s)\n", detail.RegionScore, current.Region, detail.Entity.Region)
+				fmt.Fprintf(w, "  - 语言得分: %d (当前语言%s, 候选语言%s)\n", detail.LanguageScore, current.Language, detail.Entity.Language)
+				fmt.Fprintf(w, "  - 角色平衡得分: %d\n", detail.BalanceScore)
+				fmt.Fprintf(w, "  - 标签得分: %d (当前标签%v, 候选标签%v)\n", detail.TagScore, current.Tags, detail.Entity.Tags)
+				fmt.Fprintf(w, "  - 新鲜度得分: %d\n", detail.FreshnessScore)
+				fmt.Fprintf(w, "  - 时段得分: %d\n", detail.TimeOfDayScore)
+				fmt.Fprintf(w, "  - 推广得分: %d\n", detail.BoostScore)
+				fmt.Fprintf(w, "  - 粘性得分: %d\n", detail.StickinessScore)
+				fmt.Fprintf(w, "  - 补位得分: %d (观众%d人)\n", detail.FillScore, detail.Entity.AudienceCount)
+				fmt.Fprintf(w, "  - 总分: %d\n", detail.Score)
+				break
+			}
+		}
+
+		// 显示前5名候选
+		fmt.Fprintf(w, "\n前5名候选:\n")
+		for i, detail := range rankDetails(details, 5) {
+			selected := ""
+			if detail.Entity.ID == matched.ID {
+				selected = " ⭐"
+			}
+			fmt.Fprintf(w, "  %d. %s (分数:%d, 麦位:%d, 观众:%d, 等待:%ds)%s\n",
+				i+1, detail.Entity.ID, detail.Score, detail.Entity.MicCount,
+				detail.Entity.AudienceCount, detail.Entity.WaitSeconds, selected)
+		}
+
+	} else {
+		fmt.Fprintf(w, "❌ 未找到匹配\n")
+
+		// 显示被拒绝的原因统计
+		fmt.Fprintf(w, "拒绝原因统计:\n")
+		for reason, count := range NewMatchStats(details).RejectReasons {
+			fmt.Fprintf(w, "  - %s: %d个\n", reason, count)
+		}
+	}
+}
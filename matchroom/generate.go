@@ -0,0 +1,83 @@
+package matchroom
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// globalSource - 以全局随机源为底层的 rand.Source，供兼容包装函数使用
+type globalSource struct{}
+
+func (globalSource) Int63() int64 { return rand.Int63() }
+
+func (globalSource) Seed(int64) {}
+
+// globalRand - 基于全局随机源的 *rand.Rand
+var globalRand = rand.New(globalSource{})
+
+// 随机生成实体 - 使用全局随机源
+func GenerateRandomEntity(id string) *Entity {
+	return GenerateRandomEntityWithRand(id, globalRand)
+}
+
+// 随机生成实体 - 使用指定随机源，相同种子生成相同实体
+func GenerateRandomEntityWithRand(id string, r *rand.Rand) *Entity {
+	return GenerateRandomEntityAt(id, r, time.Now().Unix())
+}
+
+// 随机生成实体 - 以 now 为基准时间生成历史匹配时间戳，相同种子和 now 生成相同实体
+func GenerateRandomEntityAt(id string, r *rand.Rand, now int64) *Entity {
+	// 生成随机的历史匹配用户（可能为空）
+	lastMatchedUsers := make(map[string]int64)
+	if r.Float32() < 0.3 { // 30%概率有历史匹配
+		numUsers := r.Intn(3) + 1 // 1-3个用户
+		for i := 0; i < numUsers; i++ {
+			userID := fmt.Sprintf("user%d", r.Intn(1000))
+			// 随机时间，0-1200秒前（0-20分钟）
+			lastMatchedUsers[userID] = now - int64(r.Intn(1201))
+		}
+	}
+
+	// 生成随机黑名单（可能为空）
+	blacklist := make(map[string]struct{})
+	if r.Float32() < 0.2 { // 20%概率有黑名单
+		numBlacklisted := r.Intn(2) + 1 // 1-2个用户
+		for i := 0; i < numBlacklisted; i++ {
+			userID := fmt.Sprintf("user%d", r.Intn(1000))
+			blacklist[userID] = struct{}{}
+		}
+	}
+
+	entity := &Entity{
+		ID:               id,
+		MicCount:         uint16(r.Intn(15) + 1),   // 1-15人
+		AudienceCount:    uint16(r.Intn(200) + 10), // 10-209人
+		WaitSeconds:      uint16(r.Intn(300) + 10), // 10-309秒
+		MatchHistory:     uint16(r.Intn(20)),       // 0-19次
+		ActivityLevel:    ActivityLevel(r.Intn(3)), // 0-2 (Low, Medium, High)
+		LastMatchedUsers: lastMatchedUsers,
+		Blacklist:        blacklist,
+	}
+	entity.RefreshSegment()
+	return entity
+}
+
+// 生成随机实体池 - 使用全局随机源
+func GenerateEntityPool(count int) []*Entity {
+	return GenerateEntityPoolWithRand(count, globalRand)
+}
+
+// 生成随机实体池 - 使用指定随机源，同一实体池内共用一个基准时间
+func GenerateEntityPoolWithRand(count int, r *rand.Rand) []*Entity {
+	return GenerateEntityPoolAt(count, r, time.Now().Unix())
+}
+
+// 生成随机实体池 - 以 now 为基准时间，相同种子和 now 生成相同实体池
+func GenerateEntityPoolAt(count int, r *rand.Rand, now int64) []*Entity {
+	entities := make([]*Entity, count)
+	for i := 0; i < count; i++ {
+		entities[i] = GenerateRandomEntityAt(fmt.Sprintf("entity_%03d", i+1), r, now)
+	}
+	return entities
+}
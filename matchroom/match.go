@@ -0,0 +1,471 @@
+package matchroom
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 指定时间匹配 - 以 now 作为当前时间判断冷却，便于测试和回放历史候选池
+func MatchAt(current *Entity, pool []*Entity, userID string, config *MatchConfig, now int64) (*Entity, []*MatchDetail) {
+	selected, details := matchDetailedWith(current, pool, userID, config, now, rand.Intn)
+	return selected.entity(), details
+}
+
+// 回放输入 - 一次匹配的全部输入，可序列化为 JSON 随匹配日志保存
+// 自定义 Scorers 和 Filters 无法序列化，回放时只使用内置打分器
+type ReplayInput struct {
+	Current *Entity      `json:"current"`
+	Pool    []*Entity    `json:"pool"`
+	UserID  string       `json:"user_id"`
+	Config  *MatchConfig `json:"config"` // 为 nil 时使用 DefaultMatchConfig
+	Now     int64        `json:"now"`    // 匹配时的 Unix 时间戳
+	Seed    int64        `json:"seed"`   // 并列选择使用的随机种子，与 rand.New(rand.NewSource(Seed)) 的第一次匹配结果一致
+}
+
+// 回放匹配 - 以记录的时间和随机种子重新执行匹配，相同输入总是得到相同结果
+func Replay(input ReplayInput) *MatchOutcome {
+	config := input.Config
+	if config == nil {
+		config = &DefaultMatchConfig
+	}
+	rng := rand.New(rand.NewSource(input.Seed))
+	selected, details := matchDetailedWith(input.Current, input.Pool, input.UserID, config, input.Now, rng.Intn)
+	return newMatchOutcome(selected, details, config)
+}
+
+// 匹配核心 - 返回选中候选的详情，intn 用于在最高分候选中随机选择
+func matchDetailedWith(current *Entity, pool []*Entity, currentUserID string, config *MatchConfig, currentTime int64, intn func(int) int) (*MatchDetail, []*MatchDetail) {
+	details, _ := scorePool(context.Background(), current, pool, currentUserID, config, currentTime)
+	return selectBest(details, config, intn), details
+}
+
+// 粘性匹配 - previousID 为同一用户上一次的匹配结果，该候选仍有效时加 StickinessBonus 分
+// 新候选需比上一次的结果高出 StickinessBonus 以上才会替换；previousID 为空时与 MatchAt 相同
+// previousID 非空但 StickinessBonus 为0或自定义 Scorers 不含 StickinessScorer 时粘性无法生效，返回错误
+func MatchSticky(current *Entity, pool []*Entity, userID string, config *MatchConfig, now int64, previousID string) (*Entity, []*MatchDetail, error) {
+	if previousID != "" && !config.stickiness() {
+		return nil, nil, fmt.Errorf("MatchSticky needs a positive StickinessBonus and a StickinessScorer")
+	}
+	details, _ := scorePoolWith(context.Background(), current, pool, userID, config, now, scoreOptions{hints: scoreHints{previousID: previousID}})
+	return selectBest(details, config, rand.Intn).entity(), details, nil
+}
+
+// 分层匹配 - 按顺序尝试每个候选池（如优先池、普通池），返回第一个有效匹配，都没有时返回 nil
+// TierMinScores[i] 为第 i 层的最低分，选中候选低于该分时继续尝试下一层；FallbackStrategy 只在最后一层生效
+func MatchTiered(current *Entity, pools [][]*Entity, userID string, config *MatchConfig) *Entity {
+	currentTime := time.Now().Unix()
+	strict := config.With(func(c *MatchConfig) { c.FallbackStrategy = FallbackNone })
+	for i, pool := range pools {
+		tierConfig := strict
+		if i == len(pools)-1 {
+			tierConfig = config
+		}
+		selected, _ := matchDetailedWith(current, pool, userID, tierConfig, currentTime, rand.Intn)
+		if selected == nil {
+			continue
+		}
+		if i < len(config.TierMinScores) && !selected.Rejected && selected.Score < config.TierMinScores[i] {
+			continue
+		}
+		return selected.entity()
+	}
+	return nil
+}
+
+// 带取消的匹配 - 打分过程中定期检查 ctx，取消时返回 ctx.Err() 且不返回任何匹配结果
+func MatchWithContext(ctx context.Context, current *Entity, pool []*Entity, userID string, config *MatchConfig) (*Entity, []*MatchDetail, error) {
+	details, err := scorePool(ctx, current, pool, userID, config, time.Now().Unix())
+	if err != nil {
+		return nil, nil, err
+	}
+	return selectBest(details, config, rand.Intn).entity(), details, nil
+}
+
+// 取消检查间隔 - 每打分这么多个候选检查一次 ctx
+const ctxCheckInterval = 64
+
+// 候选池打分 - 计算候选的详细信息，受 MaxCandidatesScored 和 GoodEnoughScore 限制时只返回已打分的候选
+func scorePool(ctx context.Context, current *Entity, pool []*Entity, currentUserID string, config *MatchConfig, currentTime int64) ([]*MatchDetail, error) {
+	return scorePoolWith(ctx, current, pool, currentUserID, config, currentTime, scoreOptions{})
+}
+
+// 打分选项 - 供引擎、详情缓冲等调用方定制候选池打分，零值即普通打分
+type scoreOptions struct {
+	alloc  func() *MatchDetail                  // 提供零值详情，用于复用分配，为空时新建
+	cached func(candidate *Entity) *MatchDetail // 返回非 nil 时直接使用该详情，跳过打分
+	scored func(detail *MatchDetail)            // 每个候选打分后调用，跳过打分的候选不调用
+	hints  scoreHints                           // 传给每个候选打分的提示
+}
+
+// 候选池打分 - 按 opts 分配详情、跳过已缓存的候选并通知打分结果
+func scorePoolWith(ctx context.Context, current *Entity, pool []*Entity, currentUserID string, config *MatchConfig, currentTime int64, opts scoreOptions) ([]*MatchDetail, error) {
+	if len(pool) == 0 {
+		return nil, ctx.Err()
+	}
+
+	// 只打分前 MaxCandidatesScored 个候选
+	if config.MaxCandidatesScored > 0 && len(pool) > config.MaxCandidatesScored {
+		pool = pool[:config.MaxCandidatesScored]
+	}
+
+	// 预分配结果切片，避免频繁扩容
+	details := make([]*MatchDetail, 0, len(pool))
+	currentSeg := config.segmentOf(current)
+
+	for i := range pool {
+		if i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if opts.cached != nil {
+			if detail := opts.cached(pool[i]); detail != nil {
+				config.notifyRejected(detail)
+				config.notifyScored(detail)
+				details = append(details, detail)
+				continue
+			}
+		}
+		var detail *MatchDetail
+		if opts.alloc != nil {
+			detail = opts.alloc()
+		} else {
+			detail = &MatchDetail{}
+		}
+		scoreInto(detail, PerspectiveInitiator, current, pool[i], currentUserID, config, currentTime, currentSeg, opts.hints)
+		config.notifyScored(detail)
+		if opts.scored != nil {
+			opts.scored(detail)
+		}
+		details = append(details, detail)
+
+		// 足够好的候选 - 立即停止，后续候选不再打分
+		if config.GoodEnoughScore > 0 && !detail.Rejected && detail.Score >= config.GoodEnoughScore {
+			break
+		}
+	}
+	return details, ctx.Err()
+}
+
+// 详情对象池 - 供 DetailBuffer 复用 MatchDetail
+var detailPool = sync.Pool{
+	New: func() any { return new(MatchDetail) },
+}
+
+// 详情缓冲 - 从 sync.Pool 复用 MatchDetail，降低高并发下每个候选一次分配带来的 GC 压力
+// 所有权约定：Match 返回的详情归缓冲所有，下次调用 Match 或 Release 后即失效并可能被其他调用复用，
+// 需要保留时使用 Copy。DetailBuffer 非并发安全，每个协程应使用各自的缓冲
+type DetailBuffer struct {
+	details []*MatchDetail
+}
+
+// 匹配 - 与 MatchAt 相同，回收上一次的详情后从对象池取用
+func (b *DetailBuffer) Match(current *Entity, pool []*Entity, userID string, config *MatchConfig) (*MatchDetail, []*MatchDetail) {
+	b.Release()
+	alloc := func() *MatchDetail { return detailPool.Get().(*MatchDetail) }
+	details, _ := scorePoolWith(context.Background(), current, pool, userID, config, time.Now().Unix(), scoreOptions{alloc: alloc})
+	b.details = details
+	return selectBest(details, config, rand.Intn), details
+}
+
+// 回收详情 - 将持有的详情重置后放回对象池
+func (b *DetailBuffer) Release() {
+	for i, detail := range b.details {
+		detail.Reset()
+		detailPool.Put(detail)
+		b.details[i] = nil
+	}
+	b.details = b.details[:0]
+}
+
+// 选出最优候选 - 在所有最高分候选中按 TieBreak 选择一个，无有效匹配时按 FallbackStrategy 兜底
+func selectBest(details []*MatchDetail, config *MatchConfig, intn func(int) int) *MatchDetail {
+	var tracker topTracker
+	for _, detail := range details {
+		tracker.add(detail)
+	}
+	if selected := tracker.pick(config, intn); selected != nil {
+		return selected
+	}
+	return fallbackPick(details, config, intn)
+}
+
+// 兜底选择 - 黑名单、自身和不活跃拒绝不会被忽略；兜底选中的详情仍保留 Rejected 标记和原因
+// 候选池匹配不保留全部详情，不执行兜底
+func fallbackPick(details []*MatchDetail, config *MatchConfig, intn func(int) int) *MatchDetail {
+	if config.FallbackStrategy == FallbackNone {
+		return nil
+	}
+
+	eligible := make([]*MatchDetail, 0, len(details))
+	for _, detail := range details {
+		switch detail.RejectCode {
+		case RejectBlacklisted, RejectBlockedByCurrent, RejectSelf, RejectInactive:
+			continue
+		}
+		eligible = append(eligible, detail)
+	}
+	if len(eligible) == 0 {
+		return nil
+	}
+
+	if config.FallbackStrategy == FallbackRandomAny {
+		return eligible[intn(len(eligible))]
+	}
+
+	// BestRejected - 未被拒绝的候选以 Score 参与比较，被拒绝的候选以 RawScore 参与比较
+	var ties []*MatchDetail
+	best := int16(math.MinInt16)
+	for _, detail := range eligible {
+		score := detail.Score
+		if detail.Rejected {
+			score = detail.RawScore
+		}
+		switch {
+		case score > best:
+			best = score
+			ties = append(ties[:0], detail)
+		case score == best:
+			ties = append(ties, detail)
+		}
+	}
+	return ties[pickTie(ties, config, intn)]
+}
+
+// 最高分跟踪 - 增量维护当前最高分的并列候选，无需保留全部详情
+type topTracker struct {
+	ties []*MatchDetail
+}
+
+// 加入一个候选 - 被拒绝的候选直接忽略
+func (t *topTracker) add(detail *MatchDetail) {
+	if detail.Rejected {
+		return
+	}
+	switch {
+	case len(t.ties) == 0 || detail.Score > t.ties[0].Score:
+		t.ties = append(t.ties[:0], detail)
+	case detail.Score == t.ties[0].Score:
+		t.ties = append(t.ties, detail)
+	}
+}
+
+// 选出结果 - 最高分为负数时视为没有有效匹配
+func (t *topTracker) pick(config *MatchConfig, intn func(int) int) *MatchDetail {
+	if len(t.ties) == 0 || t.ties[0].Score < 0 {
+		return nil
+	}
+	return t.ties[pickTie(t.ties, config, intn)]
+}
+
+// 流式匹配 - 逐个消费候选，只保留最高分并列候选，通道关闭后返回结果
+// 给定相同的候选顺序，结果与 MatchAt 一致；提前停止打分后仍会读完通道，避免生产者阻塞
+func MatchStream(current *Entity, candidates <-chan *Entity, userID string, config *MatchConfig) *Entity {
+	return MatchSeq(current, func(yield func(*Entity) bool) {
+		stopped := false
+		for candidate := range candidates {
+			if !stopped {
+				stopped = !yield(candidate)
+			}
+		}
+	}, userID, config)
+}
+
+// 迭代器匹配 - 候选来自 iter.Seq，可由数据库游标或生成器提供，无需构造 []*Entity
+// 给定相同的候选序列，结果与 MatchAt 一致；未配置兜底时只保留最高分并列候选
+// 达到 MaxCandidatesScored 或 GoodEnoughScore 时停止迭代
+func MatchSeq(current *Entity, candidates iter.Seq[*Entity], userID string, config *MatchConfig) *Entity {
+	currentTime := time.Now().Unix()
+	currentSeg := config.segmentOf(current)
+	keepAll := config.FallbackStrategy != FallbackNone
+
+	var tracker topTracker
+	var details []*MatchDetail
+	scored := 0
+	for candidate := range candidates {
+		detail := scoreMatchDetailed(current, candidate, userID, config, currentTime, currentSeg)
+		config.notifyScored(detail)
+		tracker.add(detail)
+		if keepAll {
+			details = append(details, detail)
+		}
+		scored++
+		if config.GoodEnoughScore > 0 && !detail.Rejected && detail.Score >= config.GoodEnoughScore {
+			break
+		}
+		if config.MaxCandidatesScored > 0 && scored >= config.MaxCandidatesScored {
+			break
+		}
+	}
+	if selected := tracker.pick(config, rand.Intn); selected != nil {
+		return selected.entity()
+	}
+	return fallbackPick(details, config, rand.Intn).entity()
+}
+
+// 并列候选选择 - 在同分候选中按 TieBreak 选出一个，返回下标
+func pickTie(ties []*MatchDetail, config *MatchConfig, intn func(int) int) int {
+	switch config.TieBreak {
+	case TieBreakLowestID:
+		lowest := 0
+		for i, detail := range ties {
+			if detail.Entity.ID < ties[lowest].Entity.ID {
+				lowest = i
+			}
+		}
+		return lowest
+	case TieBreakLongestWait:
+		// 等待时间按10秒分段打分，同分候选的精确等待时间仍可能不同
+		longest := make([]int, 0, len(ties))
+		for i, detail := range ties {
+			if len(longest) > 0 {
+				wait, best := detail.Entity.WaitSeconds, ties[longest[0]].Entity.WaitSeconds
+				if wait < best {
+					continue
+				}
+				if wait > best {
+					longest = longest[:0]
+				}
+			}
+			longest = append(longest, i)
+		}
+		if len(longest) == 1 {
+			return longest[0]
+		}
+		return longest[intn(len(longest))]
+	case TieBreakPreferActivity:
+		// 以活跃度得分为权重加权抽取，全部为低活跃度时退化为均匀随机
+		total := 0
+		for _, detail := range ties {
+			total += int(scoreActivityLevel(detail.Entity.ActivityLevel))
+		}
+		if total > 0 {
+			r := intn(total)
+			for i, detail := range ties {
+				r -= int(scoreActivityLevel(detail.Entity.ActivityLevel))
+				if r < 0 {
+					return i
+				}
+			}
+		}
+	}
+	return intn(len(ties))
+}
+
+// 前N名候选 - 返回最多 n 个未被拒绝的候选，按分数降序，同分按ID升序
+func MatchTopN(current *Entity, pool []*Entity, userID string, config *MatchConfig, n int) []*MatchDetail {
+	details, _ := scorePool(context.Background(), current, pool, userID, config, time.Now().Unix())
+	return rankDetails(details, n)
+}
+
+// 候选排名 - 过滤被拒绝的候选后排序，保留前 n 个
+func rankDetails(details []*MatchDetail, n int) []*MatchDetail {
+	if n <= 0 {
+		return nil
+	}
+
+	valid := make([]*MatchDetail, 0, len(details))
+	for _, detail := range details {
+		if !detail.Rejected {
+			valid = append(valid, detail)
+		}
+	}
+
+	sortDetailsByScore(valid)
+	if len(valid) > n {
+		valid = valid[:n]
+	}
+	return valid
+}
+
+// 按分数排序 - 原地按分数降序排序，同分按ID升序，保证输出顺序确定
+func sortDetailsByScore(details []*MatchDetail) {
+	sort.SliceStable(details, func(i, j int) bool {
+		if details[i].Score != details[j].Score {
+			return details[i].Score > details[j].Score
+		}
+		return details[i].Entity.ID < details[j].Entity.ID
+	})
+}
+
+// 完整匹配 - 一次调用返回选中实体、详情及统计，无需调用方再次遍历
+func MatchFull(current *Entity, pool []*Entity, userID string, config *MatchConfig) *MatchOutcome {
+	selected, details := matchDetailedWith(current, pool, userID, config, time.Now().Unix(), rand.Intn)
+	return newMatchOutcome(selected, details, config)
+}
+
+// 带分数匹配 - 返回选中实体及其打分详情，无需再次打分；无有效匹配时均为 nil
+func MatchWithScore(current *Entity, pool []*Entity, userID string, config *MatchConfig) (*Entity, *MatchDetail) {
+	selected, _ := matchDetailedWith(current, pool, userID, config, time.Now().Unix(), rand.Intn)
+	return selected.entity(), selected
+}
+
+// 合并候选池 - 按实体ID去重，同一ID保留最后出现的实体，位置取该ID第一次出现的位置；nil 实体被跳过
+func MergePools(pools ...[]*Entity) []*Entity {
+	return MergePoolsFunc(func(_, next *Entity) *Entity { return next }, pools...)
+}
+
+// 合并候选池 - 同一ID重复出现时由 resolve(已保留的实体, 新出现的实体) 决定保留哪个
+func MergePoolsFunc(resolve func(kept, next *Entity) *Entity, pools ...[]*Entity) []*Entity {
+	total := 0
+	for _, pool := range pools {
+		total += len(pool)
+	}
+	merged := make([]*Entity, 0, total)
+	index := make(map[string]int, total)
+	for _, pool := range pools {
+		for _, entity := range pool {
+			if entity == nil {
+				continue
+			}
+			if i, ok := index[entity.ID]; ok {
+				if chosen := resolve(merged[i], entity); chosen != nil {
+					merged[i] = chosen
+				}
+				continue
+			}
+			index[entity.ID] = len(merged)
+			merged = append(merged, entity)
+		}
+	}
+	return merged
+}
+
+// 匹配解释 - 一次匹配的完整打分结果，可直接序列化为 JSON 写入日志
+type MatchExplanation struct {
+	CurrentID  string         `json:"current_id"`
+	UserID     string         `json:"user_id"`
+	Time       int64          `json:"time"`
+	WinnerID   string         `json:"winner_id,omitempty"`
+	Candidates []*MatchDetail `json:"candidates"`
+}
+
+// 解释匹配 - 返回所有候选的打分明细及最终选中的候选，用于排查线上匹配问题
+func ExplainMatch(current *Entity, pool []*Entity, userID string, config *MatchConfig) *MatchExplanation {
+	now := time.Now().Unix()
+	selected, details := matchDetailedWith(current, pool, userID, config, now, rand.Intn)
+
+	explanation := &MatchExplanation{
+		CurrentID:  current.ID,
+		UserID:     userID,
+		Time:       now,
+		Candidates: details,
+	}
+	if selected != nil {
+		explanation.WinnerID = selected.Entity.ID
+	}
+	return explanation
+}
+
+// 解释单对实体 - 绕过候选池，返回 a 对 b 打分的完整详情，用于排查“A 为什么没匹配到 B”
+// 以解释模式打分，被拒绝时仍给出全部子得分和第一个拒绝原因
+func ExplainPair(a, b *Entity, userID string, config *MatchConfig, now int64) *MatchDetail {
+	explain := config.With(func(c *MatchConfig) { c.ExplainMode = true })
+	return scoreMatchDetailed(a, b, userID, explain, now, config.segmentOf(a))
+}
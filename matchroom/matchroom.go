@@ -0,0 +1,263 @@
+// Package matchroom 房间匹配引擎 - 实体、匹配配置、打分详情及匹配函数，可嵌入服务使用
+package matchroom
+
+import "math"
+
+// 打分提示 - 调用方已经确认的结论及额外的打分输入，quickReject 据此跳过对应检查
+type scoreHints struct {
+	notBlacklisted bool   // 候选池的反向索引已确认候选的永久黑名单不含当前用户，只需检查临时屏蔽
+	previousID     string // 上一次选中的候选ID，写入 ScoreContext.PreviousID
+}
+
+// 候选黑名单查询回调 - 供基准测试统计 quickReject 实际查询候选永久黑名单的次数，为 nil 时不统计
+var onBlacklistLookup func()
+
+// 快速排除检查 - 提前退出优化，未被排除时返回 RejectNone
+func quickReject(current *Entity, candidate *Entity, currentUserID string, config *MatchConfig, currentTime int64) (RejectCode, string) {
+	return quickRejectWith(current, candidate, currentUserID, config, currentTime, scoreHints{})
+}
+
+// 快速排除检查 - 按 hints 跳过调用方已确认的检查
+func quickRejectWith(current *Entity, candidate *Entity, currentUserID string, config *MatchConfig, currentTime int64, hints scoreHints) (RejectCode, string) {
+	// 自身检查 - 候选池为全部房间列表时当前实体也在其中
+	if !config.AllowSelfMatch && candidate.ID == current.ID {
+		return RejectSelf, ReasonText(RejectSelf, config.Locale)
+	}
+
+	// 黑名单检查 - 含未到期的临时屏蔽
+	if !hints.notBlacklisted {
+		if onBlacklistLookup != nil {
+			onBlacklistLookup()
+		}
+		if candidate.blacklisted(currentUserID) {
+			return RejectBlacklisted, ReasonText(RejectBlacklisted, config.Locale)
+		}
+	}
+	if candidate.tempBlocked(currentUserID, currentTime) {
+		return RejectBlacklisted, ReasonText(RejectBlacklisted, config.Locale)
+	}
+
+	// 反向黑名单检查 - 候选房主在当前实体的黑名单中
+	if current.IsBlocked(candidate.Owner(), currentTime) {
+		return RejectBlockedByCurrent, ReasonText(RejectBlockedByCurrent, config.Locale)
+	}
+
+	// 数据过期检查 - 恰好等于 DataTTL 时仍有效
+	if config.DataTTL > 0 && currentTime-candidate.DataFetchedAtUnix > config.DataTTL {
+		return RejectStaleData, ReasonText(RejectStaleData, config.Locale)
+	}
+
+	// 存活检查 - 上麦和观众人数可能长时间未更新，按最近活跃时间排除僵尸房间
+	if config.LivenessTimeout > 0 && !candidate.IsLive(currentTime, config.LivenessTimeout) {
+		return RejectInactive, ReasonText(RejectInactive, config.Locale)
+	}
+
+	// 上麦人数检查 - 冷清的房间不参与匹配
+	if candidate.MicCount < config.MinMicCount {
+		return RejectMicCountTooLow, ReasonText(RejectMicCountTooLow, config.Locale)
+	}
+
+	// 观众容量检查 - 不把新用户导入已满的房间
+	if config.MaxAudienceCount > 0 && candidate.AudienceCount > config.MaxAudienceCount {
+		return RejectAudienceFull, ReasonText(RejectAudienceFull, config.Locale)
+	}
+
+	// 冷却时间检查 - 软冷却模式下由 CooldownScorer 扣分
+	if lastTime, ok := candidate.LastMatchedUsers[currentUserID]; ok && !config.softCooldown() {
+		if currentTime-lastTime < config.cooldownFor(candidate.ActivityLevel) {
+			return RejectCooldown, ReasonText(RejectCooldown, config.Locale, currentTime-lastTime)
+		}
+	}
+
+	// 严格段位检查 - 段位不同一律排除，不受等待时间影响
+	currentSeg := config.segmentOf(current)
+	candidateSeg := config.segmentOf(candidate)
+	if config.StrictSegment && currentSeg != candidateSeg {
+		return RejectStrictSegment, ReasonText(RejectStrictSegment, config.Locale)
+	}
+	if m := config.SegmentMap; m != nil && m.IsolateOverflow && currentSeg != candidateSeg &&
+		(currentSeg == m.overflow() || candidateSeg == m.overflow()) {
+		return RejectStrictSegment, ReasonText(RejectStrictSegment, config.Locale)
+	}
+
+	// 段位检查 - 如果等待时间不够且段位差距过大则排除；配置了距离得分表时按表中该距离的等待门槛判断
+	if distance := segmentDistance(currentSeg, candidateSeg); distance > 1 {
+		if table := config.SegmentDistanceScores; table == nil && candidate.WaitSeconds < 60 ||
+			table != nil && (int(distance) >= len(table) || candidate.WaitSeconds < table[distance].MinWait) {
+			return RejectSegmentGap, ReasonText(RejectSegmentGap, config.Locale, currentSeg, candidateSeg)
+		}
+	}
+
+	// 自定义准入过滤
+	for _, filter := range config.Filters {
+		if reject, reason := filter(current, candidate); reject {
+			if reason == "" {
+				reason = ReasonText(RejectFiltered, config.Locale)
+			}
+			return RejectFiltered, reason
+		}
+	}
+
+	return RejectNone, ""
+}
+
+// 主打分逻辑 - 优化计算顺序和缓存，返回详细信息
+func scoreMatchDetailed(current *Entity, candidate *Entity, currentUserID string, config *MatchConfig, currentTime int64, currentSeg uint8) *MatchDetail {
+	return scoreMatchAs(PerspectiveInitiator, current, candidate, currentUserID, config, currentTime, currentSeg)
+}
+
+// 指定视角打分 - 同一对实体在 InitiatorWeights 与 Weights 不同时，两个视角的得分不同
+func ScoreAs(perspective Perspective, current, candidate *Entity, userID string, config *MatchConfig, now int64) *MatchDetail {
+	return scoreMatchAs(perspective, current, candidate, userID, config, now, config.segmentOf(current))
+}
+
+func scoreMatchAs(perspective Perspective, current *Entity, candidate *Entity, currentUserID string, config *MatchConfig, currentTime int64, currentSeg uint8) *MatchDetail {
+	return scoreInto(&MatchDetail{}, perspective, current, candidate, currentUserID, config, currentTime, currentSeg, scoreHints{})
+}
+
+// 打分写入给定详情 - detail 必须是零值，复用的详情需先 Reset
+func scoreInto(detail *MatchDetail, perspective Perspective, current *Entity, candidate *Entity, currentUserID string, config *MatchConfig, currentTime int64, currentSeg uint8, hints scoreHints) *MatchDetail {
+	detail.Entity = candidate
+	detail.CurrentSegment = currentSeg
+	detail.CandidateSegment = config.segmentOf(candidate)
+
+	// 快速排除检查 - 解释模式下仅标记拒绝，继续计算各项子得分
+	if code, reason := quickRejectWith(current, candidate, currentUserID, config, currentTime, hints); code != RejectNone {
+		detail.reject(code, reason)
+		if !config.explain() {
+			config.notifyRejected(detail)
+			return detail
+		}
+	}
+
+	ctx := ScoreContext{
+		UserID:           currentUserID,
+		Config:           config,
+		CurrentTime:      currentTime,
+		CurrentSegment:   currentSeg,
+		CandidateSegment: detail.CandidateSegment,
+		Perspective:      perspective,
+		PreviousID:       hints.previousID,
+	}
+	total, rejected := runScorers(detail, current, candidate, ctx, allScorers)
+	if rejected {
+		detail.explainRejected(total, config)
+		config.notifyRejected(detail)
+		return detail
+	}
+	detail.finish(total, ctx)
+	if detail.Rejected {
+		config.notifyRejected(detail)
+	}
+	return detail
+}
+
+// 打分器筛选 - runScorers 只运行返回 true 的打分器
+var allScorers = func(Scorer) bool { return true }
+
+// 依次运行打分器并加权求和 - 内置打分器的得分写回 detail，任一打分器拒绝时标记拒绝并返回 true
+// 解释模式下拒绝不提前返回，保留第一个拒绝原因，其余打分器照常计算；拒绝打分器的得分只记录不计入总分
+func runScorers(detail *MatchDetail, current, candidate *Entity, ctx ScoreContext, include func(Scorer) bool) (float64, bool) {
+	config := ctx.Config
+	weights := config.weightsFor(ctx.Perspective)
+	total := 0.0
+	for _, scorer := range config.scorers() {
+		if !include(scorer) {
+			continue
+		}
+		score, rejected, reason := scorer.Score(current, candidate, ctx)
+		if rejected {
+			code := RejectScorer
+			if coder, ok := scorer.(rejectCoder); ok {
+				code = coder.rejectCode()
+			}
+			if reason == "" {
+				reason = ReasonText(code, config.Locale)
+			}
+			if !detail.Rejected {
+				detail.reject(code, reason)
+			}
+			if !config.explain() {
+				return total, true
+			}
+			if builtin, ok := scorer.(builtinScorer); ok {
+				builtin.record(detail, score)
+			}
+			continue
+		}
+
+		weight := 1.0
+		if builtin, ok := scorer.(builtinScorer); ok {
+			builtin.record(detail, score)
+			weight = builtin.weight(weights)
+		}
+		total += float64(score) * weight
+	}
+	return total, detail.Rejected
+}
+
+// 有效分数范围 - 子得分在 float64 中按权重累加，最后四舍五入并饱和到该范围再转为 int16，
+// 权重很大或打分项很多时不会回绕；下限比 MinScore 大1，未被拒绝的候选不会与拒绝哨兵值混淆
+const (
+	minValidScore = MinScore + 1
+	maxValidScore = math.MaxInt16
+)
+
+// 总分取整 - 饱和到 [minValidScore, maxValidScore]
+func clampScore(total float64) int16 {
+	return int16(min(max(math.Round(total), float64(minValidScore)), maxValidScore))
+}
+
+// 解释模式下的拒绝详情 - Score 保持 MinScore，RawScore 记录未被拒绝的打分器加权和
+func (d *MatchDetail) explainRejected(total float64, config *MatchConfig) {
+	if config.explain() {
+		d.RawScore = clampScore(total)
+	}
+}
+
+// 计算总分 - 写入原始分和归一化分，并执行最低分数线检查
+func (d *MatchDetail) finish(total float64, ctx ScoreContext) {
+	config := ctx.Config
+	d.RawScore = clampScore(total)
+	d.Score = d.RawScore
+	if config.NormalizeScore {
+		d.NormalizedScore = normalizeScore(total, config.theoreticalMaxScore(ctx.Perspective))
+		d.Score = d.NormalizedScore
+	}
+
+	// 最低分数线检查 - 分数过低的候选不作为匹配结果
+	if config.MinAcceptableScore > 0 && d.Score < config.MinAcceptableScore {
+		d.reject(RejectBelowThreshold, ReasonText(RejectBelowThreshold, config.Locale, d.Score, config.MinAcceptableScore))
+	}
+}
+
+// 理论满分 - 各内置打分器在当前配置下的最高分乘以权重后求和，最高分为负的按0计
+// 例如默认配置下为 等待52 + 段位10 + 观众5 + 历史4 + 活跃度3 + 语言5 = 79 分
+// 自定义打分器无法得知上限，不计入满分，其加分会使归一化结果被截断在100
+func (c *MatchConfig) theoreticalMaxScore(perspective Perspective) float64 {
+	weights := c.weightsFor(perspective)
+	total := 0.0
+	for _, scorer := range c.scorers() {
+		builtin, ok := scorer.(builtinScorer)
+		if !ok {
+			continue
+		}
+		bounded, ok := scorer.(maxScorer)
+		if !ok {
+			continue
+		}
+		if score := bounded.maxScore(c); score > 0 {
+			total += float64(score) * builtin.weight(weights)
+		}
+	}
+	return total
+}
+
+// 分数归一化 - 按理论满分折算为0-100，负分记为0，满分不大于0时返回0
+func normalizeScore(raw, maxScore float64) int16 {
+	if maxScore <= 0 {
+		return 0
+	}
+	normalized := math.Round(raw / maxScore * 100)
+	return int16(min(max(normalized, 0), 100))
+}
@@ -0,0 +1,3650 @@
+package matchroom
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEntityJSONRoundTrip(t *testing.T) {
+	original := NewEntity("room1",
+		WithMicCount(5),
+		WithAudienceCount(42),
+		WithWaitSeconds(90),
+		WithActivity(ActivityHigh),
+		WithBlacklist("u2", "u1"),
+		WithLastMatched("u3", 1700000000),
+	)
+
+	data, err := json.Marshal(original)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Unmarshal into map: %v", err)
+	}
+	if fields["activity_level"] != "high" {
+		t.Errorf("activity_level = %v, want \"high\"", fields["activity_level"])
+	}
+
+	var decoded Entity
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if diff := original.Diff(&decoded); diff != nil {
+		t.Errorf("round trip changed fields %v", diff)
+	}
+	if decoded.Segment() != original.Segment() {
+		t.Errorf("Segment() = %d, want %d", decoded.Segment(), original.Segment())
+	}
+}
+
+func TestEntityJSONValueKeepsBlacklist(t *testing.T) {
+	entity := NewEntity("room1", WithBlacklist("u1"))
+
+	data, err := json.Marshal(*entity)
+	if err != nil {
+		t.Fatalf("Marshal value: %v", err)
+	}
+	var decoded Entity
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal value: %v", err)
+	}
+	if !maps.Equal(decoded.Blacklist, entity.Blacklist) {
+		t.Errorf("value: Blacklist = %v, want %v", decoded.Blacklist, entity.Blacklist)
+	}
+
+	data, err = json.Marshal([]Entity{*entity})
+	if err != nil {
+		t.Fatalf("Marshal slice: %v", err)
+	}
+	var decodedSlice []Entity
+	if err := json.Unmarshal(data, &decodedSlice); err != nil {
+		t.Fatalf("Unmarshal slice: %v", err)
+	}
+	if len(decodedSlice) != 1 || !maps.Equal(decodedSlice[0].Blacklist, entity.Blacklist) {
+		t.Errorf("slice: decoded %+v, want Blacklist %v", decodedSlice, entity.Blacklist)
+	}
+}
+
+func TestActivityLevelUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    ActivityLevel
+		wantErr bool
+	}{
+		{`"low"`, ActivityLow, false},
+		{`"medium"`, ActivityMedium, false},
+		{`"high"`, ActivityHigh, false},
+		{`2`, ActivityHigh, false},
+		{`"hihg"`, 0, true},
+		{`7`, 0, true},
+		{`-1`, 0, true},
+		{`true`, 0, true},
+	}
+	for _, tt := range tests {
+		var level ActivityLevel
+		err := json.Unmarshal([]byte(tt.input), &level)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Unmarshal(%s) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && level != tt.want {
+			t.Errorf("Unmarshal(%s) = %v, want %v", tt.input, level, tt.want)
+		}
+	}
+}
+
+func TestAudienceDiffScoresCustomBand(t *testing.T) {
+	band := []int16{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+	for _, tt := range []struct {
+		diff int
+		want int16
+	}{{0, 10}, {3, 7}, {-3, 7}, {9, 1}, {10, 0}, {50, 0}} {
+		if got := scoreAudienceDiff(tt.diff, band); got != tt.want {
+			t.Errorf("scoreAudienceDiff(%d) = %d, want %d", tt.diff, got, tt.want)
+		}
+	}
+
+	config := DefaultMatchConfig
+	config.AudienceDiffScores = band
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	current := NewEntity("current", WithMicCount(3), WithAudienceCount(50))
+	candidate := NewEntity("candidate", WithMicCount(3), WithAudienceCount(58))
+	if detail := scoreMatchDetailed(current, candidate, "u", &config, 0, current.Segment()); detail.AudienceScore != 2 {
+		t.Errorf("AudienceScore = %d, want 2", detail.AudienceScore)
+	}
+}
+
+func TestAudienceDiffScoresEmptyUsesDefault(t *testing.T) {
+	config := DefaultMatchConfig
+	config.AudienceDiffScores = []int16{}
+	config.NormalizeScore = true
+	if err := config.Validate(); err == nil {
+		t.Error("Validate accepted an empty AudienceDiffScores")
+	}
+
+	current := NewEntity("current", WithMicCount(3), WithAudienceCount(50))
+	candidate := NewEntity("candidate", WithMicCount(3), WithAudienceCount(51))
+	// 未经 Validate 的入口不能因空表 panic
+	if matched, _ := MatchAt(current, []*Entity{candidate}, "u", &config, 0); matched != candidate {
+		t.Fatalf("MatchAt = %v, want candidate", matched)
+	}
+	if detail := scoreMatchDetailed(current, candidate, "u", &config, 0, current.Segment()); detail.AudienceScore != 4 {
+		t.Errorf("AudienceScore = %d, want default table value 4", detail.AudienceScore)
+	}
+}
+
+func TestDefaultAudienceDiffScoresNotAliased(t *testing.T) {
+	if &DefaultMatchConfig.AudienceDiffScores[0] == &audienceDiffScores[0] {
+		t.Fatal("DefaultMatchConfig.AudienceDiffScores shares the package-level table")
+	}
+}
+
+func TestGenerateEntityPoolAtReproducible(t *testing.T) {
+	const now = 1700000000
+	first := GenerateEntityPoolAt(50, rand.New(rand.NewSource(42)), now)
+	second := GenerateEntityPoolAt(50, rand.New(rand.NewSource(42)), now)
+	if !reflect.DeepEqual(first, second) {
+		t.Fatal("same seed and now produced different pools")
+	}
+	for _, entity := range first {
+		for userID, at := range entity.LastMatchedUsers {
+			if at > now || at < now-1200 {
+				t.Errorf("%s: LastMatchedUsers[%s] = %d, outside [now-1200, now]", entity.ID, userID, at)
+			}
+		}
+	}
+
+	other := GenerateEntityPoolAt(50, rand.New(rand.NewSource(43)), now)
+	if reflect.DeepEqual(first, other) {
+		t.Error("different seeds produced identical pools")
+	}
+}
+
+func TestEntityProtoKeepsDataFetchedAt(t *testing.T) {
+	const now = 1700000000
+	original := NewEntity("room1", WithMicCount(3), WithDataFetchedAt(now-30))
+	decoded, err := EntityFromProto(original.ToProto())
+	if err != nil {
+		t.Fatalf("EntityFromProto: %v", err)
+	}
+	if decoded.DataFetchedAtUnix != original.DataFetchedAtUnix {
+		t.Fatalf("DataFetchedAtUnix = %d, want %d", decoded.DataFetchedAtUnix, original.DataFetchedAtUnix)
+	}
+
+	config := DefaultMatchConfig
+	config.DataTTL = 60
+	current := NewEntity("current", WithMicCount(3))
+	if detail := scoreMatchDetailed(current, decoded, "u", &config, now, current.Segment()); detail.RejectCode == RejectStaleData {
+		t.Errorf("round-tripped room rejected as %s", detail.RejectCode)
+	}
+}
+
+func TestEntityProtoRoundTrip(t *testing.T) {
+	const now = 1700000000
+	original := NewEntity("room1",
+		WithOwnerID("owner1"),
+		WithRegion("sg"),
+		WithLanguage("zh"),
+		WithMicCount(5),
+		WithAudienceCount(42),
+		WithWaitSeconds(90),
+		WithMatchHistory(3),
+		WithMatchAttempts(4),
+		WithActivitySince(ActivityHigh, now-600),
+		WithBlacklist("u2", "u1"),
+		WithLastMatched("u3", now-100),
+		WithRole("singer", 2),
+		WithRole("dj", 1),
+		WithTags("music", "gaming"),
+		WithLastActive(now-5),
+		WithDataFetchedAt(now-30),
+		WithPreferredHours(20, 21),
+		WithBoost(15, now+3600),
+	)
+	original.BlockUser("u4", now+60)
+
+	decoded, err := EntityFromProto(original.ToProto())
+	if err != nil {
+		t.Fatalf("EntityFromProto: %v", err)
+	}
+	if diff := original.Diff(decoded); diff != nil {
+		t.Errorf("round trip changed fields %v", diff)
+	}
+
+	config := DefaultMatchConfig
+	config.LivenessTimeout = 60
+	current := NewEntity("current", WithMicCount(5))
+	if detail := scoreMatchDetailed(current, decoded, "u", &config, now, current.Segment()); detail.RejectCode == RejectInactive {
+		t.Errorf("round-tripped room rejected as %s", detail.RejectCode)
+	}
+}
+
+func TestEntityFromProtoRangeChecks(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *EntityProto
+	}{
+		{"mic_count", &EntityProto{Id: "r", MicCount: 1 << 16}},
+		{"role", &EntityProto{Id: "r", Roles: map[string]uint32{"singer": 1 << 16}}},
+		{"boost_high", &EntityProto{Id: "r", BoostAmount: 1 << 15}},
+		{"boost_low", &EntityProto{Id: "r", BoostAmount: -1<<15 - 1}},
+		{"activity", &EntityProto{Id: "r", ActivityLevel: 9}},
+	}
+	for _, tt := range tests {
+		if _, err := EntityFromProto(tt.msg); err == nil {
+			t.Errorf("%s: EntityFromProto accepted out-of-range message", tt.name)
+		}
+	}
+	if _, err := EntityFromProto(nil); err == nil {
+		t.Error("EntityFromProto(nil) returned no error")
+	}
+}
+
+func TestEntityProtoKeepsAllowList(t *testing.T) {
+	const now = 1700000000
+	for _, original := range []*Entity{
+		NewEntity("allow", WithAllowList("friend")),
+		NewEntity("allow_none", WithAllowList()),
+		NewEntity("block", WithBlacklist("friend")),
+	} {
+		decoded, err := EntityFromProto(original.ToProto())
+		if err != nil {
+			t.Fatalf("%s: EntityFromProto: %v", original.ID, err)
+		}
+		if diff := original.Diff(decoded); diff != nil {
+			t.Errorf("%s: round trip changed fields %v", original.ID, diff)
+		}
+		for _, userID := range []string{"friend", "stranger"} {
+			if got, want := decoded.IsBlocked(userID, now), original.IsBlocked(userID, now); got != want {
+				t.Errorf("%s: IsBlocked(%s) = %v after round trip, want %v", original.ID, userID, got, want)
+			}
+		}
+	}
+}
+
+func newRejectCacheEngine(t *testing.T, edit func(*MatchConfig)) *MatchEngine {
+	t.Helper()
+	config := DefaultMatchConfig
+	config.RejectCacheTTL = 3600
+	if edit != nil {
+		edit(&config)
+	}
+	engine, err := NewMatchEngine(&config)
+	if err != nil {
+		t.Fatalf("NewMatchEngine: %v", err)
+	}
+	return engine
+}
+
+func rejectCodeOf(t *testing.T, details []*MatchDetail, id string) RejectCode {
+	t.Helper()
+	for _, detail := range details {
+		if detail.Entity.ID == id {
+			return detail.RejectCode
+		}
+	}
+	t.Fatalf("no detail for %s", id)
+	return RejectNone
+}
+
+func TestRejectCacheScopedByConfig(t *testing.T) {
+	const now = 1700000000
+	engine := newRejectCacheEngine(t, nil)
+	current := NewEntity("current", WithMicCount(2), WithWaitSeconds(120))
+	candidate := NewEntity("candidate", WithMicCount(5), WithWaitSeconds(120))
+	pool := []*Entity{candidate}
+
+	strict := func(c *MatchConfig) { c.StrictSegment = true }
+	_, details, err := engine.MatchDetailedWithOverrides(current, pool, "u", strict)
+	if err != nil {
+		t.Fatalf("MatchDetailedWithOverrides: %v", err)
+	}
+	if code := rejectCodeOf(t, details, "candidate"); code != RejectStrictSegment {
+		t.Fatalf("override call: reject code = %s, want %s", code, RejectStrictSegment)
+	}
+	if _, details := engine.MatchAt(current, pool, "u", now); rejectCodeOf(t, details, "candidate") != RejectNone {
+		t.Errorf("override rejection leaked into a normal call: %s", rejectCodeOf(t, details, "candidate"))
+	}
+}
+
+func TestRejectCacheFlushedBySetConfig(t *testing.T) {
+	const now = 1700000000
+	engine := newRejectCacheEngine(t, func(c *MatchConfig) { c.MinMicCount = 5 })
+	current := NewEntity("current", WithMicCount(3))
+	candidate := NewEntity("candidate", WithMicCount(3))
+	pool := []*Entity{candidate}
+
+	if _, details := engine.MatchAt(current, pool, "u", now); rejectCodeOf(t, details, "candidate") != RejectMicCountTooLow {
+		t.Fatalf("reject code = %s, want %s", rejectCodeOf(t, details, "candidate"), RejectMicCountTooLow)
+	}
+	relaxed := *engine.Config()
+	relaxed.MinMicCount = 1
+	if err := engine.SetConfig(&relaxed); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	if len(engine.rejects.entries) != 0 {
+		t.Errorf("SetConfig left %d cached rejections", len(engine.rejects.entries))
+	}
+	if _, details := engine.MatchAt(current, pool, "u", now+1); rejectCodeOf(t, details, "candidate") != RejectNone {
+		t.Errorf("after SetConfig: reject code = %s, want none", rejectCodeOf(t, details, "candidate"))
+	}
+}
+
+func TestRejectCacheTimeDependentCodes(t *testing.T) {
+	const now = 1700000000
+	engine := newRejectCacheEngine(t, nil)
+	cooldown := engine.Config().RecentMatchCooldown
+	current := NewEntity("current", WithMicCount(2))
+
+	// 冷却拒绝在冷却结束时随之失效，而不是保持到 RejectCacheTTL
+	cooling := NewEntity("cooling", WithMicCount(2), WithLastMatched("u", now-10))
+	if _, details := engine.MatchAt(current, []*Entity{cooling}, "u", now); rejectCodeOf(t, details, "cooling") != RejectCooldown {
+		t.Fatalf("reject code = %s, want %s", rejectCodeOf(t, details, "cooling"), RejectCooldown)
+	}
+	if _, details := engine.MatchAt(current, []*Entity{cooling}, "u", now-10+cooldown); rejectCodeOf(t, details, "cooling") == RejectCooldown {
+		t.Error("cooldown rejection stayed cached after the cooldown elapsed")
+	}
+
+	// 段位差距拒绝缓存到等待按时间增长到60秒的时刻，此前不再打分
+	far := NewEntity("far", WithMicCount(10), WithWaitSeconds(30))
+	if _, details := engine.MatchAt(current, []*Entity{far}, "u", now); rejectCodeOf(t, details, "far") != RejectSegmentGap {
+		t.Fatalf("reject code = %s, want %s", rejectCodeOf(t, details, "far"), RejectSegmentGap)
+	}
+	if _, ok := engine.rejects.lookup(rejectKey{engine.Config(), "current", "u", "far"}, now+29); !ok {
+		t.Error("segment gap rejection not cached before the wait threshold")
+	}
+	far.WaitSeconds = 60
+	if _, details := engine.MatchAt(current, []*Entity{far}, "u", now+30); rejectCodeOf(t, details, "far") == RejectSegmentGap {
+		t.Error("segment gap rejection stayed cached after the wait reached 60 seconds")
+	}
+
+	// 相邻段位在等待不足60秒时由 SegmentScorer 拒绝，同样缓存到等待达到门槛
+	adjacent := NewEntity("adjacent", WithMicCount(5), WithWaitSeconds(30))
+	if _, details := engine.MatchAt(current, []*Entity{adjacent}, "u", now); rejectCodeOf(t, details, "adjacent") != RejectSegmentMismatch {
+		t.Fatalf("reject code = %s, want %s", rejectCodeOf(t, details, "adjacent"), RejectSegmentMismatch)
+	}
+	adjacent.WaitSeconds = 60
+	if matched, details := engine.MatchAt(current, []*Entity{adjacent}, "u", now+30); matched != adjacent {
+		t.Errorf("segment mismatch rejection stayed cached after the wait threshold: %s", rejectCodeOf(t, details, "adjacent"))
+	}
+
+	// 上麦人数变化后段位不同，缓存的段位拒绝不再沿用
+	grown := NewEntity("grown", WithMicCount(10), WithWaitSeconds(0))
+	if _, details := engine.MatchAt(current, []*Entity{grown}, "u", now); rejectCodeOf(t, details, "grown") != RejectSegmentGap {
+		t.Fatalf("reject code = %s, want %s", rejectCodeOf(t, details, "grown"), RejectSegmentGap)
+	}
+	grown.SetMicCount(2)
+	if matched, _ := engine.MatchAt(current, []*Entity{grown}, "u", now+1); matched != grown {
+		t.Error("segment rejection stayed cached after the candidate's mic count changed")
+	}
+
+	// 观众已满、数据过期、不活跃随房间数据刷新随时可能解除，不缓存
+	for code, edit := range map[RejectCode]func(c *MatchConfig){
+		RejectAudienceFull: func(c *MatchConfig) { c.MaxAudienceCount = 10 },
+		RejectStaleData:    func(c *MatchConfig) { c.DataTTL = 60 },
+		RejectInactive:     func(c *MatchConfig) { c.LivenessTimeout = 60 },
+	} {
+		attrEngine := newRejectCacheEngine(t, edit)
+		room := NewEntity("room", WithMicCount(2), WithAudienceCount(20))
+		if _, details := attrEngine.MatchAt(current, []*Entity{room}, "u", now); rejectCodeOf(t, details, "room") != code {
+			t.Fatalf("reject code = %s, want %s", rejectCodeOf(t, details, "room"), code)
+		}
+		if len(attrEngine.rejects.entries) != 0 {
+			t.Errorf("%s rejection was cached", code)
+		}
+	}
+
+	// 临时屏蔽到期后不再沿用
+	blocked := NewEntity("blocked", WithMicCount(2))
+	blocked.BlockUser("u", now+5)
+	if _, details := engine.MatchAt(current, []*Entity{blocked}, "u", now); rejectCodeOf(t, details, "blocked") != RejectBlacklisted {
+		t.Fatalf("reject code = %s, want %s", rejectCodeOf(t, details, "blocked"), RejectBlacklisted)
+	}
+	if _, details := engine.MatchAt(current, []*Entity{blocked}, "u", now+5); rejectCodeOf(t, details, "blocked") == RejectBlacklisted {
+		t.Error("temporary block stayed cached after it expired")
+	}
+}
+
+// 计数打分器 - 只统计被调用的次数，不影响得分
+type countingScorer struct {
+	calls map[string]int
+}
+
+func (s *countingScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	s.calls[candidate.ID]++
+	return 0, false, ""
+}
+
+func TestRejectCacheSkipsScoring(t *testing.T) {
+	const now = 1700000000
+	counter := &countingScorer{calls: make(map[string]int)}
+	filterCalls := 0
+	engine := newRejectCacheEngine(t, func(c *MatchConfig) {
+		c.Scorers = append([]Scorer{counter}, DefaultScorers...)
+		c.RejectCrossRegion = true
+		c.Filters = []EntityFilter{func(current, candidate *Entity) (bool, string) {
+			filterCalls++
+			return candidate.ID == "filtered", ""
+		}}
+	})
+	current := NewEntity("current", WithMicCount(2), WithRegion("sg"))
+	far := NewEntity("far", WithMicCount(2), WithRegion("us"))
+	filtered := NewEntity("filtered", WithMicCount(2))
+	pool := []*Entity{far, filtered}
+
+	for i := int64(0); i < 3; i++ {
+		_, details := engine.MatchAt(current, pool, "u", now+i)
+		if code := rejectCodeOf(t, details, "far"); code != RejectCrossRegion {
+			t.Fatalf("search %d: far reject code = %s, want %s", i, code, RejectCrossRegion)
+		}
+		if code := rejectCodeOf(t, details, "filtered"); code != RejectFiltered {
+			t.Fatalf("search %d: filtered reject code = %s, want %s", i, code, RejectFiltered)
+		}
+	}
+	// 跨地区拒绝被缓存，TTL 内的重复搜索不再打分；自定义过滤的拒绝不缓存，每次都重新执行
+	if counter.calls["far"] != 1 {
+		t.Errorf("far scored %d times over 3 searches, want 1", counter.calls["far"])
+	}
+	if filterCalls != 3+1 {
+		t.Errorf("filters ran %d times, want 4 (far once, filtered every search)", filterCalls)
+	}
+
+	// 超过 TTL 后重新打分
+	engine.MatchAt(current, pool, "u", now+engine.Config().RejectCacheTTL)
+	if counter.calls["far"] != 2 {
+		t.Errorf("far scored %d times after the TTL elapsed, want 2", counter.calls["far"])
+	}
+}
+
+func TestEntityValidate(t *testing.T) {
+	corruptSegment := NewEntity("corrupt", WithMicCount(5))
+	corruptSegment.RefreshSegment()
+	corruptSegment.segment = 3
+	directMic := NewEntity("direct", WithMicCount(5))
+	directMic.RefreshSegment()
+	directMic.MicCount = 10
+
+	tests := []struct {
+		name    string
+		entity  *Entity
+		wantErr bool
+	}{
+		{"valid", NewEntity("ok", WithMicCount(5), WithMatchHistory(2), WithMatchAttempts(4)), false},
+		{"empty_id", NewEntity(""), true},
+		{"activity", NewEntity("a", WithActivity(ActivityLevel(9))), true},
+		{"history_exceeds_attempts", NewEntity("h", WithMatchHistory(5), WithMatchAttempts(4)), true},
+		{"history_without_attempts", NewEntity("h", WithMatchHistory(5)), false},
+		{"preferred_hours", &Entity{ID: "p", PreferredHours: 1 << 24}, true},
+		{"negative_boost", NewEntity("b", WithBoost(-1, 0)), true},
+		{"direct_mic_change", directMic, false},
+		{"corrupt_segment_cache", corruptSegment, true},
+	}
+	for _, tt := range tests {
+		if err := tt.entity.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if got, want := directMic.Segment(), getMicSegment(10); got != want {
+		t.Errorf("direct_mic_change: Segment() = %d, want %d", got, want)
+	}
+}
+
+type recordingObserver struct {
+	mu    sync.Mutex
+	codes map[string][]RejectCode
+}
+
+func (o *recordingObserver) OnReject(detail *MatchDetail) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.codes == nil {
+		o.codes = make(map[string][]RejectCode)
+	}
+	o.codes[detail.Entity.ID] = append(o.codes[detail.Entity.ID], detail.RejectCode)
+}
+
+func (o *recordingObserver) take() map[string][]RejectCode {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	codes := o.codes
+	o.codes = nil
+	return codes
+}
+
+func TestRejectObserver(t *testing.T) {
+	const now = 1700000000
+	observer := &recordingObserver{}
+	config := DefaultMatchConfig
+	config.MinMicCount = 2
+	config.RejectCacheTTL = 3600
+	config.RejectObserver = observer
+
+	current := NewEntity("current", WithMicCount(2))
+	pool := []*Entity{
+		NewEntity("ok", WithMicCount(2), WithWaitSeconds(120)),
+		NewEntity("blocked", WithMicCount(2), WithWaitSeconds(120), WithBlacklist("u")),
+		NewEntity("low", WithMicCount(1), WithWaitSeconds(120)),
+		NewEntity("far", WithMicCount(10), WithWaitSeconds(30)),
+	}
+	want := map[string][]RejectCode{
+		"blocked": {RejectBlacklisted},
+		"low":     {RejectMicCountTooLow},
+		"far":     {RejectSegmentGap},
+	}
+	check := func(path string) {
+		t.Helper()
+		if got := observer.take(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: observed %v, want %v", path, got, want)
+		}
+	}
+
+	matchDetailedWith(current, pool, "u", &config, now, rand.Intn)
+	check("scorePool")
+
+	engine, err := NewMatchEngine(&config)
+	if err != nil {
+		t.Fatalf("NewMatchEngine: %v", err)
+	}
+	engine.MatchAt(current, pool, "u", now)
+	check("engine")
+	// 第二次调用 blocked 和 low 命中拒绝缓存，仍各通知一次
+	engine.MatchAt(current, pool, "u", now+1)
+	check("engine cached")
+
+	indexed := NewPool(pool...)
+	if matched := indexed.Match(current, "u", &config); matched == nil || matched.ID != "ok" {
+		t.Fatalf("Pool.Match = %v, want ok", matched)
+	}
+	check("Pool.Match")
+
+	indexed.ScoreAll(current, "u", &config, now)
+	check("ScoreAll")
+	if _, ok := indexed.RescoreWait(current, now+5); !ok {
+		t.Fatal("RescoreWait reported a stale cache")
+	}
+	check("RescoreWait")
+
+	// 未设置观察者时不通知
+	config.RejectObserver = nil
+	matchDetailedWith(current, pool, "u", &config, now, rand.Intn)
+	if got := observer.take(); got != nil {
+		t.Errorf("nil observer: observed %v", got)
+	}
+}
+
+func TestEngineBatchMatchUsesEngineRand(t *testing.T) {
+	var pool []*Entity
+	for i := 0; i < 20; i++ {
+		pool = append(pool, NewEntity(fmt.Sprintf("tie%02d", i), WithMicCount(3), WithWaitSeconds(120)))
+	}
+	var rooms []*Entity
+	var userIDs []string
+	for i := 0; i < 10; i++ {
+		rooms = append(rooms, NewEntity(fmt.Sprintf("room%d", i), WithMicCount(3)))
+		userIDs = append(userIDs, fmt.Sprintf("u%d", i))
+	}
+
+	newEngine := func() *MatchEngine {
+		engine, err := NewMatchEngineWithRand(nil, rand.New(rand.NewSource(7)))
+		if err != nil {
+			t.Fatalf("NewMatchEngineWithRand: %v", err)
+		}
+		return engine
+	}
+	first, second := newEngine().BatchMatch(rooms, pool, userIDs), newEngine().BatchMatch(rooms, pool, userIDs)
+	if len(first) != len(rooms) || !maps.Equal(first, second) {
+		t.Errorf("BatchMatch with equal seeds: %v vs %v", first, second)
+	}
+
+	detailed := newEngine().BatchMatchDetailed(rooms, pool, userIDs)
+	for roomID, matched := range first {
+		if got := detailed[roomID].Winner; got != matched {
+			t.Errorf("%s: BatchMatchDetailed winner %v, BatchMatch winner %v", roomID, got, matched)
+		}
+	}
+}
+
+func TestPoolMatchPrunesWithObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	scored := 0
+	config := DefaultMatchConfig
+	config.RejectObserver = observer
+	config.OnScored = func(*MatchDetail) { scored++ }
+
+	current := NewEntity("current", WithMicCount(2))
+	pool := NewPool(
+		NewEntity("ok", WithMicCount(2), WithWaitSeconds(120)),
+		NewEntity("blocked", WithMicCount(2), WithBlacklist("u")),
+		NewEntity("adjacent", WithMicCount(5), WithWaitSeconds(30)),
+		NewEntity("far", WithMicCount(10), WithWaitSeconds(30)),
+		NewEntity("patient", WithMicCount(5), WithWaitSeconds(120)),
+	)
+
+	if matched := pool.Match(current, "u", &config); matched == nil || matched.ID != "ok" {
+		t.Fatalf("Pool.Match = %v, want ok", matched)
+	}
+	// 被索引和段位规则跳过的候选不打分，但仍通知观察者
+	if scored != 2 {
+		t.Errorf("scored %d candidates, want 2 (ok, patient)", scored)
+	}
+	want := map[string][]RejectCode{
+		"blocked":  {RejectBlacklisted},
+		"adjacent": {RejectSegmentMismatch},
+		"far":      {RejectSegmentGap},
+	}
+	if got := observer.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("observed %v, want %v", got, want)
+	}
+
+	scored = 0
+	config.StrictSegment = true
+	pool.Match(current, "u", &config)
+	if scored != 1 {
+		t.Errorf("strict: scored %d candidates, want 1", scored)
+	}
+	want = map[string][]RejectCode{
+		"blocked":  {RejectBlacklisted},
+		"adjacent": {RejectStrictSegment},
+		"far":      {RejectStrictSegment},
+		"patient":  {RejectStrictSegment},
+	}
+	if got := observer.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("strict: observed %v, want %v", got, want)
+	}
+}
+
+func TestPoolRescoreClearsStaleScores(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.DataTTL = 300
+
+	current := NewEntity("current", WithMicCount(2))
+	pool := NewPool(NewEntity("room", WithMicCount(2), WithWaitSeconds(120), WithDataFetchedAt(now)))
+
+	details := pool.ScoreAll(current, "u", &config, now)
+	if details[0].Rejected || details[0].RawScore == 0 || details[0].WaitScore == 0 {
+		t.Fatalf("ScoreAll: %+v, want scored candidate", details[0])
+	}
+
+	// 数据过期后被快速排除，上次重算的总分和等待得分不能残留
+	details, ok := pool.RescoreWait(current, now+config.DataTTL+1)
+	if !ok {
+		t.Fatal("RescoreWait reported stale cache")
+	}
+	detail := details[0]
+	if detail.RejectCode != RejectStaleData {
+		t.Fatalf("RejectCode = %v, want RejectStaleData", detail.RejectCode)
+	}
+	if detail.RawScore != 0 || detail.NormalizedScore != 0 || detail.WaitScore != 0 || detail.SegmentScore != 0 {
+		t.Errorf("stale scores kept after rejection: %+v", detail)
+	}
+	if err := detail.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+func BenchmarkPoolRescore(b *testing.B) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	current := NewEntity("current", WithMicCount(5))
+	pool := NewPool(GenerateEntityPoolAt(5000, rand.New(rand.NewSource(1)), now)...)
+
+	b.Run("ScoreAll", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			pool.ScoreAll(current, "u", &config, now+int64(i))
+		}
+	})
+	b.Run("RescoreWait", func(b *testing.B) {
+		pool.ScoreAll(current, "u", &config, now)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, ok := pool.RescoreWait(current, now+int64(i)); !ok {
+				b.Fatal("RescoreWait reported stale cache")
+			}
+		}
+	})
+}
+
+func TestPartialWeightsFallBackToDefaults(t *testing.T) {
+	const now = 1_700_000_000
+	current := NewEntity("current", WithMicCount(2), WithAudienceCount(10), WithLanguage("zh"))
+	candidate := NewEntity("room", WithMicCount(2), WithAudienceCount(12), WithLanguage("zh"), WithWaitSeconds(120), WithMatchHistory(3))
+
+	base := ScoreAs(PerspectiveInitiator, current, candidate, "u", &DefaultMatchConfig, now)
+	config := DefaultMatchConfig
+	config.Weights = DefaultScoreWeights
+	config.Weights.WaitWeight = 2
+	partial := ScoreAs(PerspectiveInitiator, current, candidate, "u", &config, now)
+
+	// 只调整等待权重，段位、观众、历史等其余各项仍按默认权重计分
+	if want := base.RawScore + base.WaitScore; partial.RawScore != want {
+		t.Errorf("RawScore with WaitWeight 2 = %d, want %d", partial.RawScore, want)
+	}
+
+	loaded, err := LoadMatchConfig(strings.NewReader(`{"initiator_weights": {"wait_weight": 2}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	initiator := ScoreAs(PerspectiveInitiator, current, candidate, "u", loaded, now)
+	if initiator.RawScore != partial.RawScore {
+		t.Errorf("partial initiator_weights RawScore = %d, want %d", initiator.RawScore, partial.RawScore)
+	}
+	if target := ScoreAs(PerspectiveTarget, current, candidate, "u", loaded, now); target.RawScore != base.RawScore {
+		t.Errorf("target RawScore = %d, want %d", target.RawScore, base.RawScore)
+	}
+
+	// 显式写出的0不回退到默认权重，而是被 Validate 拒绝
+	for _, data := range []string{`{"weights": {"wait_weight": 0}}`, `{"initiator_weights": {"segment_weight": 0}}`} {
+		if _, err := LoadMatchConfig(strings.NewReader(data)); err == nil || !strings.Contains(err.Error(), "must be positive") {
+			t.Errorf("LoadMatchConfig(%s) = %v, want a positive-weight error", data, err)
+		}
+	}
+}
+
+func TestValidateRejectsNegativeRegionAndHistoryBonus(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*MatchConfig)
+	}{
+		{"RegionBonus", func(c *MatchConfig) { c.RegionBonus = -1 }},
+		{"RegionPenalty", func(c *MatchConfig) { c.RegionPenalty = math.MinInt16 }},
+		{"MutualHistoryBonus", func(c *MatchConfig) { c.MutualHistoryBonus = -1 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultMatchConfig
+			tt.edit(&config)
+			err := config.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.name) {
+				t.Errorf("Validate() = %v, want error mentioning %s", err, tt.name)
+			}
+		})
+	}
+}
+
+func FuzzScoreMatchDetailed(f *testing.F) {
+	f.Add(uint16(2), uint16(10), uint16(5), uint16(30), uint16(3), uint16(120), uint8(0), uint8(2), int64(0),
+		1.0, 1.0, []byte(nil), []byte(nil), false, false, false, int16(0))
+	f.Add(uint16(1), uint16(0), uint16(65535), uint16(65535), uint16(65535), uint16(65535), uint8(2), uint8(255), int64(-1),
+		1e6, 0.0, []byte{10, 0, 3, 60, 0, 200}, []byte{2, 3, 4}, true, true, true, int16(50))
+
+	const now = 1_700_000_000
+	f.Fuzz(func(t *testing.T, currentMic, currentAudience, candidateMic, candidateAudience, history, wait uint16,
+		currentActivity, candidateActivity uint8, lastMatchedAgo int64, waitWeight, segmentWeight float64,
+		segmentTable, segmentBounds []byte, softCooldown, normalize, explain bool, minAcceptable int16) {
+		config := DefaultMatchConfig
+		config.Weights = ScoreWeights{WaitWeight: waitWeight, SegmentWeight: segmentWeight}
+		config.SoftCooldown = softCooldown
+		config.CooldownPenalty = 20
+		config.NormalizeScore = normalize
+		config.ExplainMode = explain
+		config.MinAcceptableScore = minAcceptable
+		for i := 0; i+1 < len(segmentTable) && i < 16; i += 2 {
+			config.SegmentDistanceScores = append(config.SegmentDistanceScores,
+				SegmentDistanceScore{Score: int16(segmentTable[i]), MinWait: uint16(segmentTable[i+1])})
+		}
+		if len(segmentBounds) > 0 {
+			bounds := []uint16{1}
+			for _, step := range segmentBounds[:min(len(segmentBounds), 8)] {
+				bounds = append(bounds, bounds[len(bounds)-1]+uint16(step%5)+1)
+			}
+			config.SegmentMap = &SegmentMap{Bounds: bounds, IsolateOverflow: segmentBounds[0]%2 == 0}
+		}
+		if config.Validate() != nil {
+			return
+		}
+
+		current := NewEntity("current", WithMicCount(currentMic), WithAudienceCount(currentAudience),
+			WithActivity(ActivityLevel(currentActivity%3)))
+		candidate := NewEntity("candidate", WithMicCount(candidateMic), WithAudienceCount(candidateAudience),
+			WithMatchHistory(history), WithWaitSeconds(wait), WithActivity(ActivityLevel(candidateActivity%3)),
+			WithLastMatched("u", now-lastMatchedAgo))
+		detail := scoreMatchDetailed(current, candidate, "u", &config, now, config.segmentOf(current))
+		if err := detail.CheckInvariants(); err != nil {
+			t.Fatalf("%v: %+v", err, detail)
+		}
+	})
+}
+
+func TestSegmentDistanceIsSigned(t *testing.T) {
+	if got := segmentDistance(1, 3); got != 2 {
+		t.Errorf("segmentDistance(1, 3) = %d, want 2", got)
+	}
+	if got := segmentDistance(3, 1); got != 2 {
+		t.Errorf("segmentDistance(3, 1) = %d, want 2", got)
+	}
+
+	// 候选段位更高时与更低时得分一致
+	for _, tt := range []struct {
+		currentSeg, candidateSeg uint8
+		wait                     uint16
+		want                     int16
+	}{
+		{1, 2, 60, 3},
+		{2, 1, 60, 3},
+		{1, 3, 60, 0},
+		{3, 1, 60, 0},
+		{1, 3, 30, MinScore},
+	} {
+		if got := scoreMicSegment(tt.currentSeg, tt.candidateSeg, tt.wait, nil); got != tt.want {
+			t.Errorf("scoreMicSegment(%d, %d, %d) = %d, want %d", tt.currentSeg, tt.candidateSeg, tt.wait, got, tt.want)
+		}
+	}
+
+	const now = 1_700_000_000
+	current := NewEntity("current", WithMicCount(2))
+	adjacent := NewEntity("adjacent", WithMicCount(5), WithWaitSeconds(30))
+	far := NewEntity("far", WithMicCount(8), WithWaitSeconds(30))
+	if code, _ := quickReject(current, adjacent, "u", &DefaultMatchConfig, now); code != RejectNone {
+		t.Errorf("quickReject(adjacent) = %v, want RejectNone", code)
+	}
+	if code, _ := quickReject(current, far, "u", &DefaultMatchConfig, now); code != RejectSegmentGap {
+		t.Errorf("quickReject(far) = %v, want RejectSegmentGap", code)
+	}
+}
+
+func TestSeededEnginesBreakTiesIdentically(t *testing.T) {
+	const now = 1_700_000_000
+	current := NewEntity("current", WithMicCount(2))
+	var pool []*Entity
+	for i := range 10 {
+		pool = append(pool, NewEntity(fmt.Sprintf("tie-%d", i), WithMicCount(2), WithWaitSeconds(120)))
+	}
+
+	a, err := NewMatchEngineWithRand(nil, rand.New(rand.NewSource(7)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewMatchEngineWithRand(nil, rand.New(rand.NewSource(7)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	picked := map[string]bool{}
+	for i := range 20 {
+		first, _ := a.MatchAt(current, pool, "u", now)
+		second, _ := b.MatchAt(current, pool, "u", now)
+		if first == nil || second == nil || first.ID != second.ID {
+			t.Fatalf("round %d: engines picked %v and %v", i, first, second)
+		}
+		picked[first.ID] = true
+	}
+	// 相同种子只保证两个引擎一致，并列时仍然随机选择
+	if len(picked) < 2 {
+		t.Errorf("20 rounds picked only %v from a 10-way tie", picked)
+	}
+}
+
+func BenchmarkBatchMatch(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	now := time.Now().Unix()
+	pool := GenerateEntityPoolAt(5000, r, now)
+	rooms := make([]*Entity, 1000)
+	userIDs := make([]string, len(rooms))
+	for i := range rooms {
+		rooms[i] = GenerateRandomEntityAt(fmt.Sprintf("room_%04d", i), r, now)
+		userIDs[i] = fmt.Sprintf("user_%04d", i)
+	}
+	engine, err := NewMatchEngineWithRand(nil, rand.New(rand.NewSource(1)))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("Serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			engine.BatchMatch(rooms, pool, userIDs)
+		}
+	})
+	b.Run("Concurrent", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			engine.BatchMatchConcurrent(rooms, pool, userIDs, runtime.GOMAXPROCS(0))
+		}
+	})
+}
+
+func TestExclusiveAssignmentNoDuplicates(t *testing.T) {
+	var rooms, pool []*Entity
+	var userIDs []string
+	for i := range 10 {
+		rooms = append(rooms, NewEntity(fmt.Sprintf("room-%d", i), WithMicCount(2)))
+		userIDs = append(userIDs, fmt.Sprintf("user-%d", i))
+	}
+	for i := range 4 {
+		pool = append(pool, NewEntity(fmt.Sprintf("candidate-%d", i), WithMicCount(2), WithWaitSeconds(120)))
+	}
+
+	config := DefaultMatchConfig
+	config.ExclusiveAssignment = true
+	engine, err := NewMatchEngineWithRand(&config, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, results := range map[string]map[string]*Entity{
+		"BatchMatch":           engine.BatchMatch(rooms, pool, userIDs),
+		"BatchMatchConcurrent": engine.BatchMatchConcurrent(rooms, pool, userIDs, 4),
+	} {
+		if len(results) != len(pool) {
+			t.Errorf("%s matched %d rooms, want %d", name, len(results), len(pool))
+		}
+		seen := map[string]string{}
+		for roomID, matched := range results {
+			if other, ok := seen[matched.ID]; ok {
+				t.Errorf("%s assigned %s to both %s and %s", name, matched.ID, other, roomID)
+			}
+			seen[matched.ID] = roomID
+		}
+	}
+	if len(pool) != 4 {
+		t.Errorf("caller pool modified, len %d", len(pool))
+	}
+}
+
+func TestBlacklistDirections(t *testing.T) {
+	const now = 1_700_000_000
+	tests := []struct {
+		name      string
+		current   *Entity
+		candidate *Entity
+		want      RejectCode
+	}{
+		{
+			name:      "candidate blocks current user",
+			current:   NewEntity("current", WithMicCount(2)),
+			candidate: NewEntity("room", WithOwnerID("owner"), WithMicCount(2), WithBlacklist("u")),
+			want:      RejectBlacklisted,
+		},
+		{
+			name:      "current blocks candidate owner",
+			current:   NewEntity("current", WithMicCount(2), WithBlacklist("owner")),
+			candidate: NewEntity("room", WithOwnerID("owner"), WithMicCount(2)),
+			want:      RejectBlockedByCurrent,
+		},
+		{
+			name:      "current blocks candidate without owner by ID",
+			current:   NewEntity("current", WithMicCount(2), WithBlacklist("room")),
+			candidate: NewEntity("room", WithMicCount(2)),
+			want:      RejectBlockedByCurrent,
+		},
+		{
+			name:      "neither blocks",
+			current:   NewEntity("current", WithMicCount(2), WithBlacklist("someone")),
+			candidate: NewEntity("room", WithOwnerID("owner"), WithMicCount(2), WithBlacklist("other")),
+			want:      RejectNone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, reason := quickReject(tt.current, tt.candidate, "u", &DefaultMatchConfig, now)
+			if code != tt.want {
+				t.Fatalf("quickReject = %v, want %v", code, tt.want)
+			}
+			if code != RejectNone && reason != ReasonText(code, LocaleZh) {
+				t.Errorf("reason = %q, want %q", reason, ReasonText(code, LocaleZh))
+			}
+		})
+	}
+}
+
+func TestMatchAtCooldownBoundary(t *testing.T) {
+	const matchedAt = 1_700_000_000
+	cooldown := DefaultMatchConfig.RecentMatchCooldown
+	current := NewEntity("current", WithMicCount(2))
+	pool := []*Entity{NewEntity("room", WithMicCount(2), WithWaitSeconds(120), WithLastMatched("u", matchedAt))}
+
+	matched, details := MatchAt(current, pool, "u", &DefaultMatchConfig, matchedAt+cooldown-1)
+	if matched != nil || details[0].RejectCode != RejectCooldown {
+		t.Errorf("one second before cooldown ends: matched %v, code %v, want RejectCooldown", matched, details[0].RejectCode)
+	}
+	// 恰好经过冷却时间时冷却已结束
+	matched, details = MatchAt(current, pool, "u", &DefaultMatchConfig, matchedAt+cooldown)
+	if matched == nil || matched.ID != "room" {
+		t.Errorf("exactly at cooldown boundary: matched %v (code %v), want room", matched, details[0].RejectCode)
+	}
+}
+
+func TestMatchConfigValidate(t *testing.T) {
+	if err := DefaultMatchConfig.Validate(); err != nil {
+		t.Fatalf("DefaultMatchConfig.Validate() = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(*MatchConfig)
+		want string
+	}{
+		{"negative MinWaitTime", func(c *MatchConfig) { c.MinWaitTime = -1 }, "MinWaitTime must be non-negative"},
+		{"MinWaitTime above MaxWaitTime", func(c *MatchConfig) { c.MinWaitTime, c.MaxWaitTime = 400, 300 }, "must not exceed MaxWaitTime"},
+		{"negative RecentMatchCooldown", func(c *MatchConfig) { c.RecentMatchCooldown = -1 }, "RecentMatchCooldown"},
+		{"negative CooldownByActivity", func(c *MatchConfig) { c.CooldownByActivity[ActivityHigh] = -1 }, "CooldownByActivity[high]"},
+		{"negative weight", func(c *MatchConfig) { c.Weights.WaitWeight = -1 }, "WaitWeight must be positive"},
+		{"zero weight", func(c *MatchConfig) { c.Weights.LanguageWeight = 0 }, "LanguageWeight must be positive"},
+		{"NaN weight", func(c *MatchConfig) { c.Weights.SegmentWeight = math.NaN() }, "SegmentWeight must be finite"},
+		{"infinite weight", func(c *MatchConfig) { c.Weights.AudienceWeight = math.Inf(1) }, "AudienceWeight must be finite"},
+		{"invalid initiator weight", func(c *MatchConfig) { w := DefaultScoreWeights; w.HistoryWeight = -1; c.InitiatorWeights = &w }, "InitiatorWeights: HistoryWeight"},
+		{"unknown WaitCurve", func(c *MatchConfig) { c.WaitCurve = WaitCurveLogarithmic + 1 }, "unknown WaitCurve"},
+		{"unknown TieBreak", func(c *MatchConfig) { c.TieBreak = TieBreakLongestWait + 1 }, "unknown TieBreak"},
+		{"unknown FallbackStrategy", func(c *MatchConfig) { c.FallbackStrategy = FallbackBestRejected + 1 }, "unknown FallbackStrategy"},
+		{"empty AudienceDiffScores", func(c *MatchConfig) { c.AudienceDiffScores = []int16{} }, "AudienceDiffScores must not be empty"},
+		{"negative segment distance score", func(c *MatchConfig) { c.SegmentDistanceScores = []SegmentDistanceScore{{Score: -1}} }, "SegmentDistanceScores[0]"},
+		{"unsorted SegmentMap", func(c *MatchConfig) { c.SegmentMap = &SegmentMap{Bounds: []uint16{1, 5, 5}} }, "strictly increasing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultMatchConfig
+			tt.edit(&config)
+			err := config.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.want)
+			}
+			// 引擎构造时校验配置，不合法的配置直接失败
+			if engine, err := NewMatchEngine(&config); err == nil || engine != nil {
+				t.Errorf("NewMatchEngine = %v, %v, want error", engine, err)
+			}
+		})
+	}
+}
+
+func TestWriteMatchDetails(t *testing.T) {
+	const now = 1_700_000_000
+	current := NewEntity("current", WithMicCount(2), WithAudienceCount(10))
+	pool := []*Entity{
+		NewEntity("room", WithMicCount(2), WithAudienceCount(12), WithWaitSeconds(120), WithMatchHistory(3)),
+		NewEntity("blocked", WithMicCount(2), WithBlacklist("u")),
+	}
+
+	matched, details := MatchAt(current, pool, "u", &DefaultMatchConfig, now)
+	var buf bytes.Buffer
+	WriteMatchDetails(&buf, current, matched, details)
+	out := buf.String()
+	for _, line := range []string{
+		"当前实体: current (麦位:2, 观众:10, 等待:0秒, 段位:1)",
+		"✅ 匹配成功: room",
+		"匹配原因:",
+		fmt.Sprintf("  - 等待时间得分: %d (等待120秒)", details[0].WaitScore),
+		"  - 段位得分: 10 (当前段位1, 候选段位1)",
+		fmt.Sprintf("  - 观众差异得分: %d (观众差-2)", details[0].AudienceScore),
+		fmt.Sprintf("  - 历史得分: %d (历史匹配3次)", details[0].HistoryScore),
+		fmt.Sprintf("  - 总分: %d", details[0].Score),
+		fmt.Sprintf("  1. room (分数:%d, 麦位:2, 观众:12, 等待:120s) ⭐", details[0].Score),
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("output missing line %q:\n%s", line, out)
+		}
+	}
+
+	buf.Reset()
+	WriteMatchDetails(&buf, current, nil, details[1:])
+	out = buf.String()
+	for _, line := range []string{"❌ 未找到匹配", "拒绝原因统计:", fmt.Sprintf("  - %s: 1个", ReasonText(RejectBlacklisted, LocaleZh))} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("output missing line %q:\n%s", line, out)
+		}
+	}
+}
+
+func TestEntitySegmentCacheInvalidation(t *testing.T) {
+	e := NewEntity("room", WithMicCount(2))
+	if got := e.Segment(); got != 1 {
+		t.Fatalf("Segment() = %d, want 1", got)
+	}
+
+	// 直接修改 MicCount 时缓存失效，按新的上麦人数重新计算
+	e.MicCount = 8
+	if got := e.Segment(); got != 3 {
+		t.Errorf("after direct MicCount change Segment() = %d, want 3", got)
+	}
+	if err := e.Validate(); err != nil {
+		t.Errorf("Validate() after direct MicCount change = %v", err)
+	}
+
+	e.SetMicCount(5)
+	if got := e.Segment(); got != 2 {
+		t.Errorf("after SetMicCount(5) Segment() = %d, want 2", got)
+	}
+	e.MicCount = 0
+	e.RefreshSegment()
+	if got := e.Segment(); got != 0 {
+		t.Errorf("after RefreshSegment Segment() = %d, want 0", got)
+	}
+}
+
+func BenchmarkEntitySegment(b *testing.B) {
+	const now = 1_700_000_000
+	cached := GenerateEntityPoolAt(5000, rand.New(rand.NewSource(1)), now)
+	// 未写入缓存的实体每次都按上麦人数重新计算段位
+	uncached := GenerateEntityPoolAt(5000, rand.New(rand.NewSource(1)), now)
+	for _, e := range uncached {
+		e.segmentCached = false
+	}
+
+	for _, bench := range []struct {
+		name string
+		pool []*Entity
+	}{
+		{"Cached", cached},
+		{"Uncached", uncached},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			calls := 0
+			onMicSegment = func() { calls++ }
+			defer func() { onMicSegment = nil }()
+			var sum int
+			for i := 0; i < b.N; i++ {
+				for _, e := range bench.pool {
+					sum += int(DefaultMatchConfig.segmentOf(e))
+				}
+			}
+			_ = sum
+			b.ReportMetric(float64(calls)/float64(b.N), "segment-calls/op")
+		})
+	}
+}
+
+func TestTempBlockExpiryBoundary(t *testing.T) {
+	const until = 1_700_000_000
+	current := NewEntity("current", WithMicCount(2))
+	candidate := NewEntity("room", WithMicCount(2))
+	candidate.BlockUser("u", until)
+
+	if code, _ := quickReject(current, candidate, "u", &DefaultMatchConfig, until-1); code != RejectBlacklisted {
+		t.Errorf("one second before expiry: quickReject = %v, want RejectBlacklisted", code)
+	}
+	// 到期时刻（不含）起屏蔽失效
+	if code, _ := quickReject(current, candidate, "u", &DefaultMatchConfig, until); code != RejectNone {
+		t.Errorf("exactly at expiry: quickReject = %v, want RejectNone", code)
+	}
+	if code, _ := quickReject(current, candidate, "other", &DefaultMatchConfig, until-1); code != RejectNone {
+		t.Errorf("other user: quickReject = %v, want RejectNone", code)
+	}
+
+	// 反向屏蔽同样按到期时间判断
+	current.BlockUser(candidate.Owner(), until)
+	if code, _ := quickReject(current, candidate, "other", &DefaultMatchConfig, until-1); code != RejectBlockedByCurrent {
+		t.Errorf("reverse block before expiry: quickReject = %v, want RejectBlockedByCurrent", code)
+	}
+	if code, _ := quickReject(current, candidate, "other", &DefaultMatchConfig, until); code != RejectNone {
+		t.Errorf("reverse block at expiry: quickReject = %v, want RejectNone", code)
+	}
+}
+
+func TestTieBreakPreferActivity(t *testing.T) {
+	const now = 1_700_000_000
+	current := NewEntity("current", WithMicCount(2))
+	pool := []*Entity{
+		NewEntity("low", WithMicCount(2), WithWaitSeconds(120), WithActivity(ActivityLow)),
+		NewEntity("medium", WithMicCount(2), WithWaitSeconds(120), WithActivity(ActivityMedium)),
+		NewEntity("high", WithMicCount(2), WithWaitSeconds(120), WithActivity(ActivityHigh)),
+	}
+	// 只按等待时间打分，三个候选同分
+	config := DefaultMatchConfig
+	config.Scorers = []Scorer{WaitScorer{}}
+
+	counts := map[TieBreakMode]map[string]int{}
+	for _, mode := range []TieBreakMode{TieBreakUniform, TieBreakPreferActivity} {
+		config := config
+		config.TieBreak = mode
+		engine, err := NewMatchEngineWithRand(&config, rand.New(rand.NewSource(42)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[mode] = map[string]int{}
+		for range 3000 {
+			matched, _ := engine.MatchAt(current, pool, "u", now)
+			counts[mode][matched.ID]++
+		}
+	}
+
+	// 权重为活跃度得分 0:2:3，低活跃度不会被选中，高活跃度约占60%
+	prefer := counts[TieBreakPreferActivity]
+	if prefer["low"] != 0 {
+		t.Errorf("PreferActivity picked low-activity room %d times", prefer["low"])
+	}
+	if prefer["high"] < 1650 || prefer["high"] > 1950 {
+		t.Errorf("PreferActivity picked high %d/3000 times, want about 1800", prefer["high"])
+	}
+	if prefer["high"] <= prefer["medium"] {
+		t.Errorf("PreferActivity picked high %d times, medium %d times", prefer["high"], prefer["medium"])
+	}
+	if uniform := counts[TieBreakUniform]; prefer["high"] <= uniform["high"] || uniform["low"] == 0 {
+		t.Errorf("PreferActivity high %d vs Uniform %v", prefer["high"], uniform)
+	}
+}
+
+func TestAudienceModes(t *testing.T) {
+	const now = 1_700_000_000
+	tests := []struct {
+		current, candidate uint16
+		mode               AudienceMode
+		want               int16
+	}{
+		{10, 20, AudienceAbsolute, 0},
+		{200, 210, AudienceAbsolute, 0},
+		{10, 20, AudienceRatio, 1},
+		{200, 210, AudienceRatio, 5},
+		{20, 10, AudienceRatio, 1},
+		{0, 0, AudienceRatio, 5},
+	}
+	for _, tt := range tests {
+		config := DefaultMatchConfig
+		config.AudienceMode = tt.mode
+		current := NewEntity("current", WithMicCount(2), WithAudienceCount(tt.current))
+		candidate := NewEntity("room", WithMicCount(2), WithAudienceCount(tt.candidate), WithWaitSeconds(120))
+		detail := ScoreAs(PerspectiveInitiator, current, candidate, "u", &config, now)
+		if detail.AudienceScore != tt.want {
+			t.Errorf("mode %d, %d vs %d: AudienceScore = %d, want %d", tt.mode, tt.current, tt.candidate, detail.AudienceScore, tt.want)
+		}
+	}
+}
+
+func TestStrictSegment(t *testing.T) {
+	const now = 1_700_000_000
+	current := NewEntity("current", WithMicCount(2))
+	adjacent := NewEntity("adjacent", WithMicCount(5), WithWaitSeconds(120))
+
+	lenient := ScoreAs(PerspectiveInitiator, current, adjacent, "u", &DefaultMatchConfig, now)
+	if lenient.Rejected || lenient.SegmentScore != 3 {
+		t.Errorf("lenient: rejected %v (%v), SegmentScore %d, want accepted with 3", lenient.Rejected, lenient.RejectCode, lenient.SegmentScore)
+	}
+
+	config := DefaultMatchConfig
+	config.StrictSegment = true
+	strict := ScoreAs(PerspectiveInitiator, current, adjacent, "u", &config, now)
+	if strict.RejectCode != RejectStrictSegment {
+		t.Errorf("strict: RejectCode = %v, want RejectStrictSegment", strict.RejectCode)
+	}
+	same := NewEntity("same", WithMicCount(3), WithWaitSeconds(120))
+	if detail := ScoreAs(PerspectiveInitiator, current, same, "u", &config, now); detail.Rejected || detail.SegmentScore != 10 {
+		t.Errorf("strict same segment: rejected %v, SegmentScore %d, want accepted with 10", detail.Rejected, detail.SegmentScore)
+	}
+}
+
+func TestLowScoreDistinguishableFromRejected(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.RegionPenalty = 900
+	current := NewEntity("current", WithMicCount(2), WithRegion("sh"))
+
+	// 跨地区扣分后总分接近拒绝哨兵值，但仍是有效分数
+	penalised := ScoreAs(PerspectiveInitiator, current, NewEntity("far", WithMicCount(2), WithRegion("us")), "u", &config, now)
+	if penalised.Rejected || penalised.RejectCode != RejectNone {
+		t.Fatalf("penalised candidate rejected: %v", penalised.RejectReason)
+	}
+	if penalised.Score >= -800 || penalised.Score == MinScore {
+		t.Errorf("penalised Score = %d, want a very low score other than MinScore", penalised.Score)
+	}
+	if err := penalised.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+
+	rejected := ScoreAs(PerspectiveInitiator, current, NewEntity("blocked", WithMicCount(2), WithBlacklist("u")), "u", &config, now)
+	if !rejected.Rejected || rejected.Score != MinScore {
+		t.Errorf("rejected: Rejected %v, Score %d, want true, %d", rejected.Rejected, rejected.Score, MinScore)
+	}
+
+	// 得分为0的候选仍是有效匹配，被拒绝的候选不会被选中
+	config.Scorers = []Scorer{RegionScorer{}}
+	pool := []*Entity{
+		NewEntity("blocked", WithMicCount(2), WithBlacklist("u")),
+		NewEntity("unknown-region", WithMicCount(2)),
+	}
+	matched, details := MatchAt(current, pool, "u", &config, now)
+	if matched == nil || matched.ID != "unknown-region" {
+		t.Errorf("MatchAt = %v, want unknown-region", matched)
+	}
+	if details[1].Score != 0 || details[1].Rejected {
+		t.Errorf("zero-score candidate: Score %d, Rejected %v", details[1].Score, details[1].Rejected)
+	}
+}
+
+func TestMatchStream(t *testing.T) {
+	current := NewEntity("current", WithMicCount(2), WithAudienceCount(10))
+	pool := []*Entity{
+		NewEntity("short-wait", WithMicCount(2), WithAudienceCount(10), WithWaitSeconds(30)),
+		NewEntity("blocked", WithMicCount(2), WithAudienceCount(10), WithWaitSeconds(300), WithBlacklist("u")),
+		NewEntity("best", WithMicCount(2), WithAudienceCount(10), WithWaitSeconds(240)),
+		NewEntity("far", WithMicCount(10), WithAudienceCount(10), WithWaitSeconds(30)),
+	}
+
+	candidates := make(chan *Entity)
+	go func() {
+		defer close(candidates)
+		for _, e := range pool {
+			candidates <- e
+		}
+	}()
+	matched := MatchStream(current, candidates, "u", &DefaultMatchConfig)
+	want, _ := MatchAt(current, pool, "u", &DefaultMatchConfig, time.Now().Unix())
+	if matched == nil || want == nil || matched.ID != "best" || want.ID != "best" {
+		t.Errorf("MatchStream = %v, MatchAt = %v, want best", matched, want)
+	}
+
+	empty := make(chan *Entity)
+	close(empty)
+	if matched := MatchStream(current, empty, "u", &DefaultMatchConfig); matched != nil {
+		t.Errorf("MatchStream on closed channel = %v, want nil", matched)
+	}
+}
+
+func BenchmarkPoolMatch(b *testing.B) {
+	const now = 1_700_000_000
+	entities := GenerateEntityPoolAt(5000, rand.New(rand.NewSource(1)), now)
+	pool := NewPool(entities...)
+	current := NewEntity("current", WithMicCount(2))
+
+	scored := 0
+	config := DefaultMatchConfig
+	config.OnScored = func(*MatchDetail) { scored++ }
+
+	b.Run("FlatSlice", func(b *testing.B) {
+		scored = 0
+		for i := 0; i < b.N; i++ {
+			MatchAt(current, entities, "u", &config, now)
+		}
+		b.ReportMetric(float64(scored)/float64(b.N), "scored/op")
+	})
+	b.Run("Pool", func(b *testing.B) {
+		scored = 0
+		for i := 0; i < b.N; i++ {
+			pool.Match(current, "u", &config)
+		}
+		b.ReportMetric(float64(scored)/float64(b.N), "scored/op")
+	})
+}
+
+func TestPoolMatchAndRemove(t *testing.T) {
+	current := NewEntity("current", WithMicCount(2))
+	pool := NewPool(
+		NewEntity("a", WithMicCount(2), WithWaitSeconds(240)),
+		NewEntity("b", WithMicCount(2), WithWaitSeconds(120)),
+		NewEntity("c", WithMicCount(2), WithWaitSeconds(30)),
+	)
+
+	if !pool.Remove("b") || pool.Remove("b") {
+		t.Fatal("Remove(b) should succeed once")
+	}
+	var got []string
+	for range 3 {
+		if matched := pool.MatchAndRemove(current, "u", &DefaultMatchConfig); matched != nil {
+			got = append(got, matched.ID)
+		}
+	}
+	// 按分数依次取出，已移除的实体不再出现
+	if want := []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MatchAndRemove sequence = %v, want %v", got, want)
+	}
+	if pool.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", pool.Len())
+	}
+
+	// 并发调用时每个实体只被取出一次
+	for i := range 50 {
+		pool.Add(NewEntity(fmt.Sprintf("room-%d", i), WithMicCount(2), WithWaitSeconds(120)))
+	}
+	var mu sync.Mutex
+	seen := map[string]int{}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				matched := pool.MatchAndRemove(current, "u", &DefaultMatchConfig)
+				if matched == nil {
+					return
+				}
+				mu.Lock()
+				seen[matched.ID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != 50 {
+		t.Errorf("concurrent MatchAndRemove returned %d distinct rooms, want 50", len(seen))
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("%s returned %d times", id, n)
+		}
+	}
+}
+
+func TestWaitScoreCappedAtMaxWaitTime(t *testing.T) {
+	config := DefaultMatchConfig
+	tests := []struct {
+		seconds uint16
+		want    int16
+	}{
+		{uint16(config.MinWaitTime), 0},
+		{30, 1},
+		{60, 4},
+		{uint16(config.MaxWaitTime), 52},
+		{uint16(config.MaxWaitTime) + 1, 52},
+		{600, 52},
+		{math.MaxUint16, 52},
+	}
+	for _, tt := range tests {
+		if got := scoreWaitTime(tt.seconds, &config); got != tt.want {
+			t.Errorf("scoreWaitTime(%d) = %d, want %d", tt.seconds, got, tt.want)
+		}
+	}
+	if got := (WaitScorer{}).maxScore(&config); got != 52 {
+		t.Errorf("WaitScorer maxScore = %d, want 52", got)
+	}
+}
+
+func TestParseActivityLevelStrict(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    ActivityLevel
+		wantErr bool
+	}{
+		{"low", ActivityLow, false},
+		{"medium", ActivityMedium, false},
+		{"high", ActivityHigh, false},
+		{"hgih", ActivityLow, true},
+		{"High", ActivityLow, true},
+		{"", ActivityLow, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseActivityLevelStrict(tt.input)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseActivityLevelStrict(%q) = %v, %v; want %v, error %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+		// 宽松版本保持原有行为，未知取值按低活跃度处理
+		if lenient := ParseActivityLevel(tt.input); lenient != tt.want {
+			t.Errorf("ParseActivityLevel(%q) = %v, want %v", tt.input, lenient, tt.want)
+		}
+	}
+}
+
+func TestEntityCloneIsDeep(t *testing.T) {
+	original := NewEntity("room", WithMicCount(2), WithBlacklist("blocked"), WithLastMatched("u", 100),
+		WithRole("singer", 1), WithTags("music"))
+	original.BlockUser("temp", 200)
+	before := original.Clone()
+
+	clone := original.Clone()
+	if !clone.Equal(original) {
+		t.Fatalf("clone differs from original: %v", clone.Diff(original))
+	}
+	clone.Blacklist["other"] = struct{}{}
+	delete(clone.Blacklist, "blocked")
+	clone.LastMatchedUsers["u"] = 999
+	clone.LastMatchedUsers["v"] = 1
+	clone.TempBlocks["temp"] = 0
+	clone.Roles["singer"] = 5
+	clone.Tags[0] = "gaming"
+
+	if diff := original.Diff(before); diff != nil {
+		t.Errorf("mutating the clone changed the original: %v", diff)
+	}
+}
+
+func TestSoftCooldown(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.SoftCooldown = true
+	config.CooldownPenalty = 20
+	cooldown := config.RecentMatchCooldown
+	current := NewEntity("current", WithMicCount(2))
+
+	tests := []struct {
+		name    string
+		matched int64 // 距上次匹配的秒数，为-1表示从未匹配
+		want    int16
+	}{
+		{"never matched", -1, 0},
+		{"just matched", 0, -20},
+		{"mid cooldown", cooldown / 2, -10},
+		{"fully cooled", cooldown, 0},
+	}
+	var scores []int16
+	for _, tt := range tests {
+		candidate := NewEntity("room", WithMicCount(2), WithWaitSeconds(120))
+		if tt.matched >= 0 {
+			candidate.LastMatchedUsers["u"] = now - tt.matched
+		}
+		detail := ScoreAs(PerspectiveInitiator, current, candidate, "u", &config, now)
+		if detail.Rejected {
+			t.Errorf("%s: rejected (%v) under soft cooldown", tt.name, detail.RejectCode)
+			continue
+		}
+		if detail.CooldownScore != tt.want {
+			t.Errorf("%s: CooldownScore = %d, want %d", tt.name, detail.CooldownScore, tt.want)
+		}
+		scores = append(scores, detail.Score)
+
+		// 默认硬冷却模式下冷却期内直接拒绝
+		hard := ScoreAs(PerspectiveInitiator, current, candidate, "u", &DefaultMatchConfig, now)
+		if wantReject := tt.want != 0; (hard.RejectCode == RejectCooldown) != wantReject {
+			t.Errorf("%s: hard cooldown RejectCode = %v", tt.name, hard.RejectCode)
+		}
+	}
+	if len(scores) == len(tests) && !(scores[1] < scores[2] && scores[2] < scores[3] && scores[0] == scores[3]) {
+		t.Errorf("scores %v, want just matched < mid cooldown < fully cooled == never matched", scores)
+	}
+}
+
+func TestActivityMomentum(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.ActivityMomentumBonus = 10
+	config.ActivityMomentumWindow = 600
+	current := NewEntity("current", WithMicCount(2))
+
+	tests := []struct {
+		name  string
+		level ActivityLevel
+		since int64
+		want  int16 // 近期升级加成
+	}{
+		{"fresh high", ActivityHigh, now, 10},
+		{"half window", ActivityHigh, now - 300, 5},
+		{"long high past window", ActivityHigh, now - 600, 0},
+		{"long high far past window", ActivityHigh, now - 86400, 0},
+		{"since unknown", ActivityHigh, 0, 0},
+		{"fresh low", ActivityLow, now, 0},
+		// 进入时间晚于当前时间（时钟偏差）按刚进入处理，不超过满额加成
+		{"since in future", ActivityHigh, now + 120, 10},
+	}
+	for _, tt := range tests {
+		candidate := NewEntity("room", WithMicCount(2), WithWaitSeconds(120), WithActivitySince(tt.level, tt.since))
+		if got := scoreActivityMomentum(tt.level, tt.since, now, config.ActivityMomentumBonus, config.ActivityMomentumWindow); got != tt.want {
+			t.Errorf("%s: scoreActivityMomentum = %d, want %d", tt.name, got, tt.want)
+		}
+		detail := ScoreAs(PerspectiveInitiator, current, candidate, "u", &config, now)
+		if detail.ActivityMomentum != tt.want {
+			t.Errorf("%s: ActivityMomentum = %d, want %d", tt.name, detail.ActivityMomentum, tt.want)
+		}
+		if want := scoreActivityLevel(tt.level) + tt.want; detail.ActivityScore != want {
+			t.Errorf("%s: ActivityScore = %d, want %d", tt.name, detail.ActivityScore, want)
+		}
+	}
+
+	// 刚升级的房间排在早已高活跃的房间之前
+	fresh := NewEntity("fresh", WithMicCount(2), WithWaitSeconds(120), WithActivitySince(ActivityHigh, now-60))
+	stale := NewEntity("stale", WithMicCount(2), WithWaitSeconds(120), WithActivitySince(ActivityHigh, now-7200))
+	if got, _ := MatchAt(current, []*Entity{stale, fresh}, "u", &config, now); got != fresh {
+		t.Errorf("MatchAt picked %v, want the freshly high room", got)
+	}
+}
+
+func TestSoftCooldownRequiresPenaltyAndScorer(t *testing.T) {
+	const now = 1_700_000_000
+	// 开启软冷却但未设置扣分时冷却会被悄悄忽略，Validate 应拒绝
+	config := DefaultMatchConfig
+	config.SoftCooldown = true
+	if err := config.Validate(); err == nil {
+		t.Error("Validate accepted SoftCooldown with zero CooldownPenalty")
+	}
+	config.CooldownPenalty = 20
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate rejected SoftCooldown with positive CooldownPenalty: %v", err)
+	}
+
+	// 自定义打分器不含 CooldownScorer 时无人扣分，冷却期内仍直接拒绝
+	current := NewEntity("current", WithMicCount(2))
+	candidate := NewEntity("room", WithMicCount(2), WithWaitSeconds(120))
+	candidate.LastMatchedUsers["u"] = now
+	config.Scorers = []Scorer{SegmentScorer{}, WaitScorer{}}
+	if detail := ScoreAs(PerspectiveInitiator, current, candidate, "u", &config, now); detail.RejectCode != RejectCooldown {
+		t.Errorf("without CooldownScorer RejectCode = %v, want %v", detail.RejectCode, RejectCooldown)
+	}
+	config.Scorers = append(config.Scorers, CooldownScorer{})
+	if detail := ScoreAs(PerspectiveInitiator, current, candidate, "u", &config, now); detail.Rejected || detail.CooldownScore != -20 {
+		t.Errorf("with CooldownScorer got rejected=%v CooldownScore=%d, want not rejected and -20", detail.Rejected, detail.CooldownScore)
+	}
+}
+
+func TestMatchOverridesLeaveBaseConfig(t *testing.T) {
+	current := NewEntity("current", WithMicCount(2), WithAudienceCount(10))
+	pool := []*Entity{
+		NewEntity("patient", WithMicCount(2), WithAudienceCount(50), WithWaitSeconds(300)),
+		NewEntity("similar", WithMicCount(2), WithAudienceCount(10), WithWaitSeconds(70)),
+	}
+	base := DefaultMatchConfig
+	engine, err := NewMatchEngine(&base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 几乎不计等待时间时观众人数相近的候选胜出
+	light := DefaultScoreWeights
+	light.WaitWeight = 0.01
+	matched, _, err := engine.MatchDetailedWithOverrides(current, pool, "u", WithWeights(light))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matched == nil || matched.ID != "similar" {
+		t.Errorf("override call matched %v, want similar", matched)
+	}
+
+	if matched := engine.Match(current, pool, "u"); matched == nil || matched.ID != "patient" {
+		t.Errorf("normal call after override matched %v, want patient", matched)
+	}
+	if engine.Config() != &base || base.Weights != DefaultScoreWeights {
+		t.Errorf("base config changed: weights %+v", engine.Config().Weights)
+	}
+
+	if _, _, err := engine.MatchDetailedWithOverrides(current, pool, "u", WithWeights(ScoreWeights{WaitWeight: -1})); err == nil {
+		t.Error("invalid override accepted")
+	}
+}
+
+func TestExplainMatch(t *testing.T) {
+	current := NewEntity("current", WithMicCount(2))
+	pool := []*Entity{
+		NewEntity("best", WithMicCount(2), WithWaitSeconds(240)),
+		NewEntity("ok", WithMicCount(2), WithWaitSeconds(60)),
+		NewEntity("blocked", WithMicCount(2), WithBlacklist("u")),
+	}
+
+	explanation := ExplainMatch(current, pool, "u", &DefaultMatchConfig)
+	if len(explanation.Candidates) != len(pool) {
+		t.Fatalf("got %d candidates, want %d", len(explanation.Candidates), len(pool))
+	}
+	if explanation.WinnerID != "best" || explanation.CurrentID != "current" || explanation.UserID != "u" {
+		t.Errorf("explanation = %+v", explanation)
+	}
+
+	data, err := json.Marshal(explanation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		WinnerID   string `json:"winner_id"`
+		Candidates []struct {
+			EntityID     string     `json:"entity_id"`
+			WaitScore    int16      `json:"wait_score"`
+			Rejected     bool       `json:"rejected"`
+			RejectCode   RejectCode `json:"reject_code"`
+			RejectReason string     `json:"reject_reason"`
+		} `json:"candidates"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Candidates) != len(pool) || decoded.WinnerID != "best" {
+		t.Fatalf("decoded %s", data)
+	}
+	for i, candidate := range decoded.Candidates {
+		if candidate.EntityID != pool[i].ID {
+			t.Errorf("candidate %d entity_id = %q, want %q", i, candidate.EntityID, pool[i].ID)
+		}
+	}
+	if blocked := decoded.Candidates[2]; !blocked.Rejected || blocked.RejectCode != RejectBlacklisted || blocked.RejectReason == "" {
+		t.Errorf("blocked candidate = %+v", blocked)
+	}
+	if decoded.Candidates[0].WaitScore == 0 {
+		t.Error("sub-scores missing from JSON")
+	}
+}
+
+func TestSuccessRateScore(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.SuccessRateMaxScore = 10
+	current := NewEntity("current", WithMicCount(2))
+
+	tests := []struct {
+		name                string
+		successes, attempts uint16
+		want                int16
+	}{
+		{"new room without attempts", 0, 0, 0},
+		{"all attempts succeeded", 10, 10, 10},
+		{"one in five", 10, 50, 2},
+	}
+	for _, tt := range tests {
+		candidate := NewEntity("room", WithMicCount(2), WithWaitSeconds(120), WithMatchHistory(tt.successes), WithMatchAttempts(tt.attempts))
+		detail := ScoreAs(PerspectiveInitiator, current, candidate, "u", &config, now)
+		if detail.SuccessRateScore != tt.want {
+			t.Errorf("%s: SuccessRateScore = %d, want %d", tt.name, detail.SuccessRateScore, tt.want)
+		}
+		// 历史次数得分照常计算，两者叠加
+		if detail.HistoryScore != scoreMatchHistory(tt.successes) {
+			t.Errorf("%s: HistoryScore = %d, want %d", tt.name, detail.HistoryScore, scoreMatchHistory(tt.successes))
+		}
+		if disabled := ScoreAs(PerspectiveInitiator, current, candidate, "u", &DefaultMatchConfig, now); disabled.SuccessRateScore != 0 {
+			t.Errorf("%s: SuccessRateScore with scoring disabled = %d", tt.name, disabled.SuccessRateScore)
+		}
+	}
+
+	room := NewEntity("room", WithMicCount(2), WithWaitSeconds(120), WithMatchHistory(10), WithMatchAttempts(10))
+	matched, details := MatchAt(current, []*Entity{room}, "u", &config, now)
+	var buf bytes.Buffer
+	WriteMatchDetails(&buf, current, matched, details)
+	if line := "  - 成功率得分: 10 (成功10次/尝试10次)\n"; !strings.Contains(buf.String(), line) {
+		t.Errorf("output missing %q:\n%s", line, buf.String())
+	}
+}
+
+func TestPruneMatchHistory(t *testing.T) {
+	const now = 1_700_000_000
+	const cooldown = 600
+	e := NewEntity("room",
+		WithLastMatched("fresh", now-10),
+		WithLastMatched("almost", now-cooldown+1),
+		WithLastMatched("boundary", now-cooldown),
+		WithLastMatched("stale", now-10*cooldown),
+	)
+
+	// 恰好经过冷却时间的记录已过冷却期，同样删除
+	if removed := e.PruneMatchHistory(now, cooldown); removed != 2 {
+		t.Errorf("PruneMatchHistory removed %d entries, want 2", removed)
+	}
+	want := map[string]int64{"fresh": now - 10, "almost": now - cooldown + 1}
+	if !maps.Equal(e.LastMatchedUsers, want) {
+		t.Errorf("LastMatchedUsers = %v, want %v", e.LastMatchedUsers, want)
+	}
+	if removed := e.PruneMatchHistory(now, cooldown); removed != 0 {
+		t.Errorf("second prune removed %d entries, want 0", removed)
+	}
+
+	empty := &Entity{ID: "empty"}
+	if removed := empty.PruneMatchHistory(now, cooldown); removed != 0 {
+		t.Errorf("prune on nil map removed %d entries", removed)
+	}
+}
+
+func TestMutualHistoryBonus(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.MutualHistoryBonus = 6
+	cooldown := config.RecentMatchCooldown
+
+	pair := func(ago int64) (*Entity, *Entity) {
+		current := NewEntity("current", WithMicCount(2), WithLastMatched("room", now-ago))
+		candidate := NewEntity("room", WithMicCount(2), WithWaitSeconds(120), WithLastMatched("u", now-ago))
+		return current, candidate
+	}
+
+	// 冷却期内互相匹配过 - 直接拒绝，不加分
+	current, candidate := pair(cooldown / 2)
+	if detail := ScoreAs(PerspectiveInitiator, current, candidate, "u", &config, now); detail.RejectCode != RejectCooldown {
+		t.Errorf("mutual-recent: RejectCode = %v, want RejectCooldown", detail.RejectCode)
+	}
+
+	// 冷却期外互相匹配过 - 加分
+	current, candidate = pair(2 * cooldown)
+	older := ScoreAs(PerspectiveInitiator, current, candidate, "u", &config, now)
+	if older.Rejected || older.MutualHistoryScore != 6 {
+		t.Errorf("mutual-older: rejected %v, MutualHistoryScore %d, want accepted with 6", older.Rejected, older.MutualHistoryScore)
+	}
+
+	// 只有一方记得对方 - 不加分
+	current.LastMatchedUsers = map[string]int64{}
+	if oneSided := ScoreAs(PerspectiveInitiator, current, candidate, "u", &config, now); oneSided.MutualHistoryScore != 0 {
+		t.Errorf("one-sided: MutualHistoryScore = %d, want 0", oneSided.MutualHistoryScore)
+	}
+
+	// 超出有效窗口 - 不加分
+	config.MutualHistoryWindow = cooldown
+	current, candidate = pair(2 * cooldown)
+	if stale := ScoreAs(PerspectiveInitiator, current, candidate, "u", &config, now); stale.MutualHistoryScore != 0 {
+		t.Errorf("outside window: MutualHistoryScore = %d, want 0", stale.MutualHistoryScore)
+	}
+}
+
+func TestMatchFull(t *testing.T) {
+	current := NewEntity("current", WithMicCount(2))
+	pool := []*Entity{
+		NewEntity("best", WithMicCount(2), WithWaitSeconds(240)),
+		NewEntity("ok", WithMicCount(2), WithWaitSeconds(60)),
+		NewEntity("blocked", WithMicCount(2), WithBlacklist("u")),
+	}
+
+	outcome := MatchFull(current, pool, "u", &DefaultMatchConfig)
+	if outcome.Winner == nil || outcome.WinnerDetail == nil || outcome.WinnerDetail.Entity != outcome.Winner {
+		t.Fatalf("Winner %v and WinnerDetail %+v refer to different entities", outcome.Winner, outcome.WinnerDetail)
+	}
+	if outcome.Winner.ID != "best" {
+		t.Errorf("Winner = %s, want best", outcome.Winner.ID)
+	}
+	if len(outcome.AllDetails) != len(pool) {
+		t.Errorf("AllDetails has %d entries, want %d", len(outcome.AllDetails), len(pool))
+	}
+	if s := outcome.Stats; s.TotalCandidates != 3 || s.RejectedCount != 1 || s.ValidCount != 2 || s.RejectCodes[RejectBlacklisted] != 1 {
+		t.Errorf("Stats = %+v", s)
+	}
+
+	none := MatchFull(current, pool[2:], "u", &DefaultMatchConfig)
+	if none.Winner != nil || none.WinnerDetail != nil || none.Quality != MatchQualityNone {
+		t.Errorf("no-match outcome = %+v", none)
+	}
+}
+
+func TestMatchWithScore(t *testing.T) {
+	current := NewEntity("current", WithMicCount(2))
+	pool := []*Entity{
+		NewEntity("best", WithMicCount(2), WithWaitSeconds(240)),
+		NewEntity("ok", WithMicCount(2), WithWaitSeconds(60)),
+		NewEntity("blocked", WithMicCount(2), WithBlacklist("u")),
+	}
+
+	matched, detail := MatchWithScore(current, pool, "u", &DefaultMatchConfig)
+	if matched == nil || matched.ID != "best" || detail == nil || detail.Entity != matched {
+		t.Fatalf("MatchWithScore = %v, %+v, want best with its detail", matched, detail)
+	}
+	// 返回的详情与单独打分一致，无需再次打分
+	if want := ScoreAs(PerspectiveInitiator, current, matched, "u", &DefaultMatchConfig, time.Now().Unix()); detail.Score != want.Score {
+		t.Errorf("detail.Score = %d, want %d", detail.Score, want.Score)
+	}
+
+	if matched, detail := MatchWithScore(current, pool[2:], "u", &DefaultMatchConfig); matched != nil || detail != nil {
+		t.Errorf("no match: got %v, %+v, want nil, nil", matched, detail)
+	}
+}
+
+func TestDataTTL(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.DataTTL = 60
+	current := NewEntity("current", WithMicCount(3))
+
+	tests := []struct {
+		name      string
+		fetchedAt int64
+		stale     bool
+	}{
+		{"fresh", now - 5, false},
+		// 恰好等于 DataTTL 时仍有效
+		{"at ttl", now - 60, false},
+		{"past ttl", now - 61, true},
+		// 拉取时间未知按过期处理
+		{"unknown", 0, true},
+	}
+	for _, tt := range tests {
+		candidate := NewEntity("room", WithMicCount(3), WithWaitSeconds(120), WithDataFetchedAt(tt.fetchedAt))
+		wantCode := RejectNone
+		if tt.stale {
+			wantCode = RejectStaleData
+		}
+		if code, _ := quickReject(current, candidate, "u", &config, now); code != wantCode {
+			t.Errorf("%s: quickReject = %v, want %v", tt.name, code, wantCode)
+		}
+	}
+}
+
+func TestLivenessTimeout(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.LivenessTimeout = 60
+	current := NewEntity("current", WithMicCount(3))
+
+	tests := []struct {
+		name       string
+		lastActive int64
+		live       bool
+	}{
+		{"just active", now - 5, true},
+		// 恰好等于 LivenessTimeout 时仍存活
+		{"at timeout", now - 60, true},
+		{"past timeout", now - 61, false},
+		// 活跃时间未知视为不存活
+		{"unknown", 0, false},
+	}
+	for _, tt := range tests {
+		candidate := NewEntity("room", WithMicCount(3), WithWaitSeconds(120), WithLastActive(tt.lastActive))
+		if got := candidate.IsLive(now, config.LivenessTimeout); got != tt.live {
+			t.Errorf("%s: IsLive = %v, want %v", tt.name, got, tt.live)
+		}
+		wantCode := RejectNone
+		if !tt.live {
+			wantCode = RejectInactive
+		}
+		if code, _ := quickReject(current, candidate, "u", &config, now); code != wantCode {
+			t.Errorf("%s: quickReject = %v, want %v", tt.name, code, wantCode)
+		}
+	}
+}
+
+func TestMinMicCount(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.MinMicCount = 3
+	current := NewEntity("current", WithMicCount(5))
+
+	sparse := NewEntity("sparse", WithMicCount(1), WithWaitSeconds(120))
+	code, reason := quickReject(current, sparse, "u", &config, now)
+	if code != RejectMicCountTooLow || reason != "上麦人数不足" {
+		t.Errorf("1-mic room: quickReject = %v %q, want RejectMicCountTooLow 上麦人数不足", code, reason)
+	}
+	if code, _ := quickReject(current, NewEntity("busy", WithMicCount(5), WithWaitSeconds(120)), "u", &config, now); code != RejectNone {
+		t.Errorf("5-mic room: quickReject = %v, want RejectNone", code)
+	}
+	if code, _ := quickReject(current, NewEntity("exact", WithMicCount(3), WithWaitSeconds(120)), "u", &config, now); code != RejectNone {
+		t.Errorf("3-mic room: quickReject = %v, want RejectNone", code)
+	}
+	if code, _ := quickReject(current, sparse, "u", &DefaultMatchConfig, now); code == RejectMicCountTooLow {
+		t.Error("MinMicCount 0 still rejects sparse rooms")
+	}
+}
+
+func TestMaxAudienceCount(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.MaxAudienceCount = 100
+	current := NewEntity("current", WithMicCount(2))
+
+	atCap := NewEntity("at-cap", WithMicCount(2), WithAudienceCount(100))
+	if code, _ := quickReject(current, atCap, "u", &config, now); code != RejectNone {
+		t.Errorf("audience equal to cap: quickReject = %v, want RejectNone", code)
+	}
+	overCap := NewEntity("over-cap", WithMicCount(2), WithAudienceCount(101))
+	if code, reason := quickReject(current, overCap, "u", &config, now); code != RejectAudienceFull || reason != "观众已满" {
+		t.Errorf("one over cap: quickReject = %v %q, want RejectAudienceFull 观众已满", code, reason)
+	}
+	if code, _ := quickReject(current, overCap, "u", &DefaultMatchConfig, now); code != RejectNone {
+		t.Errorf("MaxAudienceCount 0: quickReject = %v, want RejectNone", code)
+	}
+}
+
+func TestEntityFilters(t *testing.T) {
+	config := DefaultMatchConfig
+	config.Filters = []EntityFilter{
+		func(current, candidate *Entity) (bool, string) {
+			return strings.HasPrefix(candidate.ID, "banned-"), "地区封禁"
+		},
+		func(current, candidate *Entity) (bool, string) {
+			return strings.HasPrefix(candidate.ID, "minor-"), ""
+		},
+	}
+	current := NewEntity("current", WithMicCount(2))
+	pool := []*Entity{
+		NewEntity("banned-1", WithMicCount(2), WithWaitSeconds(300)),
+		NewEntity("minor-1", WithMicCount(2), WithWaitSeconds(300)),
+		NewEntity("room-1", WithMicCount(2), WithWaitSeconds(60)),
+	}
+
+	matched, details := MatchAt(current, pool, "u", &config, 1_700_000_000)
+	if matched == nil || matched.ID != "room-1" {
+		t.Errorf("matched %v, want room-1", matched)
+	}
+	if d := details[0]; d.RejectCode != RejectFiltered || d.RejectReason != "地区封禁" {
+		t.Errorf("banned-1: %v %q, want RejectFiltered 地区封禁", d.RejectCode, d.RejectReason)
+	}
+	// 过滤器未给出原因时使用默认文案
+	if d := details[1]; d.RejectCode != RejectFiltered || d.RejectReason != ReasonText(RejectFiltered, LocaleZh) {
+		t.Errorf("minor-1: %v %q, want RejectFiltered with default reason", d.RejectCode, d.RejectReason)
+	}
+	if details[2].Rejected {
+		t.Errorf("room-1 rejected: %s", details[2].RejectReason)
+	}
+}
+
+func TestBatchMatchDetailed(t *testing.T) {
+	rooms := []*Entity{
+		NewEntity("room-a", WithMicCount(2)),
+		NewEntity("room-b", WithMicCount(2)),
+		NewEntity("room-c", WithMicCount(10), WithBlacklist("candidate-1")),
+	}
+	userIDs := []string{"user-a", "user-b", "user-c"}
+	pool := []*Entity{
+		NewEntity("candidate-1", WithMicCount(2), WithWaitSeconds(120)),
+		NewEntity("candidate-2", WithMicCount(2), WithWaitSeconds(30), WithBlacklist("user-b")),
+	}
+	engine, err := NewMatchEngineWithRand(nil, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := engine.BatchMatchDetailed(rooms, pool, userIDs)
+	if len(results) != len(rooms) {
+		t.Fatalf("got %d outcomes, want %d", len(results), len(rooms))
+	}
+	for _, room := range rooms {
+		outcome := results[room.ID]
+		if outcome == nil || len(outcome.AllDetails) != len(pool) {
+			t.Fatalf("%s: outcome %+v, want details for every candidate", room.ID, outcome)
+		}
+		// 详情引用候选池中的实体，不复制候选池
+		for i, detail := range outcome.AllDetails {
+			if detail.Entity != pool[i] {
+				t.Errorf("%s: detail %d references a copy of %s", room.ID, i, pool[i].ID)
+			}
+		}
+	}
+	if got := results["room-b"].AllDetails[1].RejectCode; got != RejectBlacklisted {
+		t.Errorf("room-b candidate-2 RejectCode = %v, want RejectBlacklisted", got)
+	}
+	// 没有匹配的房间同样返回详情
+	if c := results["room-c"]; c.Winner != nil || c.Stats.RejectedCount != 2 {
+		t.Errorf("room-c outcome: winner %v, stats %+v", c.Winner, c.Stats)
+	}
+}
+
+// 统计 quickReject 查询候选永久黑名单的次数，返回还原函数
+func countBlacklistLookups(count *int) func() {
+	onBlacklistLookup = func() { *count++ }
+	return func() { onBlacklistLookup = nil }
+}
+
+func TestPoolSkipsBlacklistLookups(t *testing.T) {
+	now := time.Now().Unix() // Pool.Match 按当前时间判断临时屏蔽
+	current := NewEntity("current", WithMicCount(3))
+	blocker := NewEntity("blocker", WithMicCount(3), WithWaitSeconds(300), WithBlacklist("user-1"))
+	allowList := NewEntity("allow-list", WithMicCount(3), WithWaitSeconds(300), WithAllowList("user-2"))
+	tempBlocked := NewEntity("temp", WithMicCount(3), WithWaitSeconds(300))
+	tempBlocked.BlockUser("user-1", now+3600)
+	open := NewEntity("open", WithMicCount(3), WithWaitSeconds(120))
+	pool := NewPool(blocker, allowList, tempBlocked, open)
+
+	lookups := 0
+	defer countBlacklistLookups(&lookups)()
+	if matched := pool.Match(current, "user-1", &DefaultMatchConfig); matched != open {
+		t.Errorf("Pool.Match = %v, want open", matched)
+	}
+	// 只有不进入索引的白名单实体需要查询，临时屏蔽仍然生效
+	if lookups != 1 {
+		t.Errorf("blacklist lookups = %d, want 1", lookups)
+	}
+
+	// ScoreAll 不跳过拉黑的实体，拉黑者和白名单实体需要查询
+	lookups = 0
+	details := pool.ScoreAll(current, "user-1", &DefaultMatchConfig, now)
+	if lookups != 2 {
+		t.Errorf("ScoreAll blacklist lookups = %d, want 2", lookups)
+	}
+	codes := map[string]RejectCode{}
+	for _, detail := range details {
+		codes[detail.Entity.ID] = detail.RejectCode
+	}
+	want := map[string]RejectCode{"blocker": RejectBlacklisted, "allow-list": RejectBlacklisted, "temp": RejectBlacklisted, "open": RejectNone}
+	if !maps.Equal(codes, want) {
+		t.Errorf("ScoreAll codes = %v, want %v", codes, want)
+	}
+}
+
+func BenchmarkPoolBlacklist(b *testing.B) {
+	const now = 1_700_000_000
+	r := rand.New(rand.NewSource(1))
+	// 所有候选与当前实体同段位，段位分桶不会跳过任何候选，差异只来自黑名单索引
+	entities := make([]*Entity, 5000)
+	for i := range entities {
+		e := NewEntity(fmt.Sprintf("room_%04d", i), WithMicCount(3), WithWaitSeconds(uint16(r.Intn(300))))
+		for range 20 {
+			e.Blacklist[fmt.Sprintf("user_%d", r.Intn(100000))] = struct{}{}
+		}
+		// 只有少数房间拉黑了目标用户
+		if i%1000 == 0 {
+			e.Blacklist["target"] = struct{}{}
+		}
+		entities[i] = e
+	}
+	pool := NewPool(entities...)
+	current := NewEntity("current", WithMicCount(3))
+
+	lookups := 0
+	defer countBlacklistLookups(&lookups)()
+	b.Run("FlatSlice", func(b *testing.B) {
+		lookups = 0
+		for i := 0; i < b.N; i++ {
+			MatchAt(current, entities, "target", &DefaultMatchConfig, now)
+		}
+		b.ReportMetric(float64(lookups)/float64(b.N), "lookups/op")
+	})
+	b.Run("Pool", func(b *testing.B) {
+		lookups = 0
+		for i := 0; i < b.N; i++ {
+			pool.Match(current, "target", &DefaultMatchConfig)
+		}
+		b.ReportMetric(float64(lookups)/float64(b.N), "lookups/op")
+	})
+}
+
+type rejectingScorer struct{}
+
+func (rejectingScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	return 0, true, ""
+}
+
+func TestRejectCodes(t *testing.T) {
+	const now = 1_700_000_000
+	tests := []struct {
+		want      RejectCode
+		edit      func(*MatchConfig)
+		current   []EntityOption
+		candidate []EntityOption
+	}{
+		{RejectNone, nil, nil, nil},
+		{RejectBlacklisted, nil, nil, []EntityOption{WithBlacklist("u")}},
+		{RejectBlockedByCurrent, nil, []EntityOption{WithBlacklist("room")}, nil},
+		{RejectMicCountTooLow, func(c *MatchConfig) { c.MinMicCount = 3 }, nil, nil},
+		{RejectAudienceFull, func(c *MatchConfig) { c.MaxAudienceCount = 10 }, nil, []EntityOption{WithAudienceCount(11)}},
+		{RejectCooldown, nil, nil, []EntityOption{WithLastMatched("u", now-1)}},
+		{RejectSegmentGap, nil, nil, []EntityOption{WithMicCount(8), WithWaitSeconds(30)}},
+		{RejectSegmentMismatch, nil, nil, []EntityOption{WithMicCount(5), WithWaitSeconds(30)}},
+		{RejectCrossRegion, func(c *MatchConfig) { c.RejectCrossRegion = true }, []EntityOption{WithRegion("sh")}, []EntityOption{WithRegion("us")}},
+		{RejectFiltered, func(c *MatchConfig) {
+			c.Filters = []EntityFilter{func(_, _ *Entity) (bool, string) { return true, "" }}
+		}, nil, nil},
+		{RejectScorer, func(c *MatchConfig) { c.Scorers = []Scorer{WaitScorer{}, rejectingScorer{}} }, nil, nil},
+		{RejectBelowThreshold, func(c *MatchConfig) { c.MinAcceptableScore = 1000 }, nil, nil},
+		{RejectStrictSegment, func(c *MatchConfig) { c.StrictSegment = true }, nil, []EntityOption{WithMicCount(5)}},
+		{RejectStaleData, func(c *MatchConfig) { c.DataTTL = 60 }, nil, []EntityOption{WithDataFetchedAt(now - 61)}},
+		{RejectInactive, func(c *MatchConfig) { c.LivenessTimeout = 60 }, nil, []EntityOption{WithLastActive(now - 61)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want.String(), func(t *testing.T) {
+			config := DefaultMatchConfig
+			if tt.edit != nil {
+				tt.edit(&config)
+			}
+			current := NewEntity("current", append([]EntityOption{WithMicCount(2)}, tt.current...)...)
+			candidate := NewEntity("room", append([]EntityOption{WithMicCount(2), WithWaitSeconds(120)}, tt.candidate...)...)
+			detail := ScoreAs(PerspectiveInitiator, current, candidate, "u", &config, now)
+			if detail.RejectCode != tt.want {
+				t.Errorf("RejectCode = %v (%q), want %v", detail.RejectCode, detail.RejectReason, tt.want)
+			}
+			if err := detail.CheckInvariants(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	// 自身检查 - 当前实体出现在候选池中
+	self := NewEntity("current", WithMicCount(2))
+	if detail := ScoreAs(PerspectiveInitiator, self, self, "u", &DefaultMatchConfig, now); detail.RejectCode != RejectSelf {
+		t.Errorf("self: RejectCode = %v, want RejectSelf", detail.RejectCode)
+	}
+}
+
+func TestReasonTextLocales(t *testing.T) {
+	if got, want := ReasonText(RejectCooldown, LocaleEn, int64(120)), "cooldown not elapsed (matched 120 seconds ago)"; got != want {
+		t.Errorf("English cooldown reason = %q, want %q", got, want)
+	}
+	if got, want := ReasonText(RejectCooldown, "", int64(120)), "冷却时间未满（120秒前匹配过）"; got != want {
+		t.Errorf("default cooldown reason = %q, want %q", got, want)
+	}
+	// 未知语言回退到中文
+	if got, want := ReasonText(RejectAudienceFull, "fr"), "观众已满"; got != want {
+		t.Errorf("unknown locale reason = %q, want %q", got, want)
+	}
+
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.Locale = LocaleEn
+	current := NewEntity("current", WithMicCount(2))
+	candidate := NewEntity("room", WithMicCount(2), WithLastMatched("u", now-120))
+	if detail := ScoreAs(PerspectiveInitiator, current, candidate, "u", &config, now); detail.RejectReason != "cooldown not elapsed (matched 120 seconds ago)" {
+		t.Errorf("RejectReason = %q", detail.RejectReason)
+	}
+}
+
+func TestScoreStats(t *testing.T) {
+	detailsOf := func(scores ...int16) []*MatchDetail {
+		details := make([]*MatchDetail, len(scores))
+		for i, score := range scores {
+			details[i] = &MatchDetail{Score: score}
+		}
+		return details
+	}
+	rejected := &MatchDetail{}
+	rejected.reject(RejectBlacklisted, ReasonText(RejectBlacklisted, LocaleZh))
+
+	details := append(detailsOf(70, 10, 100, 40, 20, 90, 30, 60, 50, 80), rejected)
+	got := ScoreStats(details)
+	want := ScoreSummary{
+		Ok: true, Count: 10, Min: 10, Max: 100, Mean: 55, Median: 55, P90: 90,
+		Histogram: map[int16]int{10: 1, 20: 1, 30: 1, 40: 1, 50: 1, 60: 1, 70: 1, 80: 1, 90: 1, 100: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ScoreStats = %+v, want %+v", got, want)
+	}
+
+	odd := ScoreStats(detailsOf(5, 1, 3, 3))
+	if odd.Median != 3 || odd.P90 != 5 || odd.Histogram[3] != 2 || odd.Mean != 3 {
+		t.Errorf("ScoreStats(5, 1, 3, 3) = %+v", odd)
+	}
+
+	for name, details := range map[string][]*MatchDetail{"empty": nil, "all rejected": {rejected}} {
+		if got := ScoreStats(details); !reflect.DeepEqual(got, ScoreSummary{}) {
+			t.Errorf("%s: ScoreStats = %+v, want zero value", name, got)
+		}
+	}
+}
+
+func TestRoleBalanceScore(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.BalanceMaxScore = 8
+	current := NewEntity("current", WithMicCount(2), WithRole("male", 3), WithRole("female", 1))
+
+	tests := []struct {
+		name      string
+		candidate *Entity
+		want      int16
+	}{
+		{"complementary", NewEntity("room", WithMicCount(2), WithRole("male", 1), WithRole("female", 3)), 8},
+		{"partly complementary", NewEntity("room", WithMicCount(2), WithRole("female", 1)), 5},
+		{"redundant", NewEntity("room", WithMicCount(2), WithRole("male", 3), WithRole("female", 1)), 0},
+		{"worse", NewEntity("room", WithMicCount(2), WithRole("male", 4)), 0},
+		{"unknown roles", NewEntity("room", WithMicCount(2)), 0},
+	}
+	for _, tt := range tests {
+		detail := ScoreAs(PerspectiveInitiator, current, tt.candidate, "u", &config, now)
+		if detail.BalanceScore != tt.want {
+			t.Errorf("%s: BalanceScore = %d, want %d", tt.name, detail.BalanceScore, tt.want)
+		}
+	}
+	if detail := ScoreAs(PerspectiveInitiator, current, tests[0].candidate, "u", &DefaultMatchConfig, now); detail.BalanceScore != 0 {
+		t.Errorf("disabled: BalanceScore = %d, want 0", detail.BalanceScore)
+	}
+}
+
+func TestSelfNeverMatched(t *testing.T) {
+	const now = 1_700_000_000
+	current := NewEntity("current", WithMicCount(2), WithWaitSeconds(300))
+	other := NewEntity("other", WithMicCount(2), WithWaitSeconds(30))
+	pool := []*Entity{current, other}
+
+	for range 20 {
+		matched, details := MatchAt(current, pool, "u", &DefaultMatchConfig, now)
+		if matched != other {
+			t.Fatalf("matched %v, want other", matched)
+		}
+		if details[0].RejectCode != RejectSelf || details[0].RejectReason != "自身" {
+			t.Fatalf("self detail: %v %q, want RejectSelf 自身", details[0].RejectCode, details[0].RejectReason)
+		}
+	}
+	if matched, _ := MatchAt(current, pool[:1], "u", &DefaultMatchConfig, now); matched != nil {
+		t.Errorf("pool of only current matched %v", matched)
+	}
+
+	// 关闭自身检查后当前实体按普通候选打分，等待时间更长因而胜出
+	config := DefaultMatchConfig
+	config.AllowSelfMatch = true
+	if matched, _ := MatchAt(current, pool, "u", &config, now); matched != current {
+		t.Errorf("AllowSelfMatch: matched %v, want current", matched)
+	}
+}
+
+func TestNormalizeScore(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.NormalizeScore = true
+	if got := config.theoreticalMaxScore(PerspectiveInitiator); got != 79 {
+		t.Errorf("theoretical max = %v, want 79", got)
+	}
+
+	current := NewEntity("current", WithMicCount(2), WithAudienceCount(10), WithLanguage("zh"))
+	perfect := NewEntity("perfect", WithMicCount(2), WithAudienceCount(10), WithLanguage("zh"),
+		WithWaitSeconds(300), WithMatchHistory(10), WithActivity(ActivityHigh))
+	detail := ScoreAs(PerspectiveInitiator, current, perfect, "u", &config, now)
+	if detail.RawScore != 79 || detail.NormalizedScore != 100 || detail.Score != 100 {
+		t.Errorf("perfect candidate: raw %d, normalized %d, score %d; want 79, 100, 100", detail.RawScore, detail.NormalizedScore, detail.Score)
+	}
+
+	// 权重翻倍时满分同步翻倍，归一化结果不变
+	config.Weights = ScoreWeights{WaitWeight: 2, SegmentWeight: 2, AudienceWeight: 2, HistoryWeight: 2, ActivityWeight: 2, LanguageWeight: 2}
+	if doubled := ScoreAs(PerspectiveInitiator, current, perfect, "u", &config, now); doubled.RawScore != 158 || doubled.NormalizedScore != 100 {
+		t.Errorf("doubled weights: raw %d, normalized %d; want 158, 100", doubled.RawScore, doubled.NormalizedScore)
+	}
+
+	average := NewEntity("average", WithMicCount(2), WithAudienceCount(12), WithWaitSeconds(60))
+	if d := ScoreAs(PerspectiveInitiator, current, average, "u", &config, now); d.NormalizedScore <= 0 || d.NormalizedScore >= 50 {
+		t.Errorf("average candidate normalized to %d (raw %d)", d.NormalizedScore, d.RawScore)
+	}
+}
+
+func TestRecordMatch(t *testing.T) {
+	const now = 1_700_000_000
+	a := NewEntity("room-a", WithMicCount(2), WithWaitSeconds(120), WithMatchHistory(5), WithMatchAttempts(5))
+	b := NewEntity("room-b", WithMicCount(2), WithWaitSeconds(120))
+
+	RecordMatch(a, b, "user-a", "user-b", now)
+	if a.LastMatchedUsers["user-b"] != now || b.LastMatchedUsers["user-a"] != now {
+		t.Errorf("LastMatchedUsers: a %v, b %v", a.LastMatchedUsers, b.LastMatchedUsers)
+	}
+	if a.MatchHistory != 6 || b.MatchHistory != 1 {
+		t.Errorf("MatchHistory: a %d, b %d; want 6, 1", a.MatchHistory, b.MatchHistory)
+	}
+	// 尝试次数同步增加，成功次数不会超过尝试次数
+	if a.MatchAttempts != 6 || b.MatchAttempts != 1 {
+		t.Errorf("MatchAttempts: a %d, b %d; want 6, 1", a.MatchAttempts, b.MatchAttempts)
+	}
+	if err := a.Validate(); err != nil {
+		t.Errorf("a after RecordMatch: %v", err)
+	}
+
+	// 随后的匹配在冷却期内被拒绝，两个方向都是
+	if matched, details := MatchAt(a, []*Entity{b}, "user-a", &DefaultMatchConfig, now+60); matched != nil || details[0].RejectCode != RejectCooldown {
+		t.Errorf("a -> b after RecordMatch: matched %v, code %v", matched, details[0].RejectCode)
+	}
+	if matched, details := MatchAt(b, []*Entity{a}, "user-b", &DefaultMatchConfig, now+60); matched != nil || details[0].RejectCode != RejectCooldown {
+		t.Errorf("b -> a after RecordMatch: matched %v, code %v", matched, details[0].RejectCode)
+	}
+
+	// 计数饱和，不会回绕
+	full := NewEntity("full", WithMatchHistory(math.MaxUint16), WithMatchAttempts(math.MaxUint16))
+	RecordMatch(full, b, "user-full", "user-b", now)
+	if full.MatchHistory != math.MaxUint16 || full.MatchAttempts != math.MaxUint16 {
+		t.Errorf("counters wrapped to %d/%d", full.MatchHistory, full.MatchAttempts)
+	}
+}
+
+func TestWaitCurves(t *testing.T) {
+	samples := []uint16{0, 20, 30, 79, 80, 160, 260, 300, 600}
+	want := map[WaitCurve][]int16{
+		WaitCurveLegacy:      {0, 0, 1, 6, 8, 24, 44, 52, 52},
+		WaitCurveStep:        {0, 0, 0, 0, 13, 26, 52, 52, 52},
+		WaitCurveLinear:      {0, 0, 2, 11, 11, 26, 45, 52, 52},
+		WaitCurveLogarithmic: {0, 0, 22, 38, 38, 46, 51, 52, 52},
+	}
+	for curve, scores := range want {
+		config := DefaultMatchConfig
+		config.WaitCurve = curve
+		for i, seconds := range samples {
+			if got := scoreWaitTime(seconds, &config); got != scores[i] {
+				t.Errorf("curve %d at %ds: score %d, want %d", curve, seconds, got, scores[i])
+			}
+		}
+		// 所有曲线在 MaxWaitTime 处得分相同，且随等待时间单调不减
+		for seconds := uint16(1); seconds <= 400; seconds++ {
+			if scoreWaitTime(seconds, &config) < scoreWaitTime(seconds-1, &config) {
+				t.Errorf("curve %d decreases at %ds", curve, seconds)
+				break
+			}
+		}
+	}
+}
+
+func TestExplainModeKeepsSubScores(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.ExplainMode = true
+	current := NewEntity("current", WithMicCount(2), WithAudienceCount(10))
+
+	for _, tt := range []struct {
+		name      string
+		candidate *Entity
+		want      RejectCode
+	}{
+		{"segment scorer reject", NewEntity("adjacent", WithMicCount(5), WithAudienceCount(10), WithWaitSeconds(30), WithMatchHistory(10)), RejectSegmentMismatch},
+		{"quickReject reject", NewEntity("far", WithMicCount(10), WithAudienceCount(10), WithWaitSeconds(30), WithMatchHistory(10)), RejectSegmentGap},
+	} {
+		detail := ScoreAs(PerspectiveInitiator, current, tt.candidate, "u", &config, now)
+		if !detail.Rejected || detail.RejectCode != tt.want || detail.Score != MinScore {
+			t.Errorf("%s: rejected %v, code %v, score %d", tt.name, detail.Rejected, detail.RejectCode, detail.Score)
+		}
+		if detail.AudienceScore != 5 || detail.HistoryScore != 4 || detail.WaitScore != 1 {
+			t.Errorf("%s: sub-scores not recorded: %+v", tt.name, detail)
+		}
+		// RawScore 只含未拒绝打分器的加权和
+		if want := detail.WaitScore + detail.AudienceScore + detail.HistoryScore; detail.RawScore != want {
+			t.Errorf("%s: RawScore = %d, want %d", tt.name, detail.RawScore, want)
+		}
+
+		// 非解释模式下拒绝提前返回，后续子得分不计算
+		plain := ScoreAs(PerspectiveInitiator, current, tt.candidate, "u", &DefaultMatchConfig, now)
+		if plain.RejectCode != tt.want || plain.AudienceScore != 0 {
+			t.Errorf("%s without explain: code %v, AudienceScore %d", tt.name, plain.RejectCode, plain.AudienceScore)
+		}
+	}
+}
+
+func TestFallbackStrategies(t *testing.T) {
+	const now = 1_700_000_000
+	current := NewEntity("current", WithMicCount(2))
+	pool := []*Entity{
+		NewEntity("blocked", WithMicCount(2), WithWaitSeconds(300), WithBlacklist("u")),
+		NewEntity("cooling", WithMicCount(2), WithWaitSeconds(300), WithLastMatched("u", now-60)),
+		NewEntity("adjacent", WithMicCount(5), WithWaitSeconds(30)),
+	}
+	engineFor := func(strategy FallbackStrategy) *MatchEngine {
+		config := DefaultMatchConfig
+		config.FallbackStrategy = strategy
+		engine, err := NewMatchEngineWithRand(&config, rand.New(rand.NewSource(3)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return engine
+	}
+
+	if matched, _ := engineFor(FallbackNone).MatchAt(current, pool, "u", now); matched != nil {
+		t.Errorf("FallbackNone matched %v, want nil", matched)
+	}
+
+	// 随机兜底 - 黑名单候选永远不会被选中
+	picked := map[string]int{}
+	random := engineFor(FallbackRandomAny)
+	for range 200 {
+		matched, _ := random.MatchAt(current, pool, "u", now)
+		if matched == nil {
+			t.Fatal("FallbackRandomAny matched nil")
+		}
+		picked[matched.ID]++
+	}
+	if picked["blocked"] != 0 || picked["cooling"] == 0 || picked["adjacent"] == 0 {
+		t.Errorf("FallbackRandomAny picks = %v", picked)
+	}
+
+	// 最高分兜底 - 按 RawScore 选择，详情保留拒绝标记
+	matched, details := engineFor(FallbackBestRejected).MatchAt(current, pool, "u", now)
+	if matched == nil || matched.ID != "cooling" {
+		t.Fatalf("FallbackBestRejected matched %v, want cooling", matched)
+	}
+	if d := details[1]; !d.Rejected || d.RejectCode != RejectCooldown || d.RawScore <= details[2].RawScore {
+		t.Errorf("BestRejected winner detail = %+v", d)
+	}
+
+	// 只剩黑名单候选时任何策略都不兜底
+	for _, strategy := range []FallbackStrategy{FallbackRandomAny, FallbackBestRejected} {
+		if matched, _ := engineFor(strategy).MatchAt(current, pool[:1], "u", now); matched != nil {
+			t.Errorf("strategy %d matched blacklisted %v", strategy, matched)
+		}
+	}
+}
+
+func TestScoreMatrix(t *testing.T) {
+	const now = 1_700_000_000
+	entities := []*Entity{
+		NewEntity("a", WithMicCount(2), WithAudienceCount(10), WithWaitSeconds(120), WithLanguage("zh")),
+		NewEntity("b", WithMicCount(3), WithAudienceCount(12), WithWaitSeconds(40), WithLanguage("zh"), WithBlacklist("user-c")),
+		NewEntity("c", WithMicCount(5), WithAudienceCount(30), WithWaitSeconds(200), WithMatchHistory(10)),
+	}
+	userIDs := []string{"user-a", "user-b", "user-c"}
+
+	matrix := ScoreMatrix(entities, userIDs, &DefaultMatchConfig, now)
+	for i := range entities {
+		for j := range entities {
+			want := ScoreAs(PerspectiveInitiator, entities[i], entities[j], userIDs[i], &DefaultMatchConfig, now).Score
+			if matrix[i][j] != want {
+				t.Errorf("matrix[%d][%d] = %d, want %d", i, j, matrix[i][j], want)
+			}
+		}
+		if matrix[i][i] != MinScore {
+			t.Errorf("diagonal [%d][%d] = %d, want MinScore", i, i, matrix[i][i])
+		}
+	}
+
+	// 黑名单只拒绝一个方向
+	if matrix[2][1] != MinScore || matrix[1][2] == MinScore {
+		t.Errorf("blacklist: c->b %d, b->c %d; want only c->b rejected", matrix[2][1], matrix[1][2])
+	}
+	// a 与 b 的对称得分相同，非对称部分（等待时间）使两个方向不同
+	if matrix[0][1] == matrix[1][0] {
+		t.Errorf("a->b and b->a both scored %d despite different wait times", matrix[0][1])
+	}
+}
+
+// 按表打分的打分器 - 表中没有的方向一律拒绝
+type tableScorer map[[2]string]int16
+
+func (s tableScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	score, ok := s[[2]string{current.ID, candidate.ID}]
+	return score, !ok, ""
+}
+
+func TestPairAllBeatsGreedy(t *testing.T) {
+	// 链 a-b-c-d: 贪心先取 b-c（12），a 和 d 无边可配，总分12；最优为 a-b + c-d，总分20
+	scorer := tableScorer{
+		{"a", "b"}: 5, {"b", "a"}: 5,
+		{"b", "c"}: 6, {"c", "b"}: 6,
+		{"c", "d"}: 5, {"d", "c"}: 5,
+	}
+	config := &MatchConfig{Scorers: []Scorer{scorer}}
+	entities := []*Entity{NewEntity("a"), NewEntity("b"), NewEntity("c"), NewEntity("d")}
+	userIDs := []string{"user-a", "user-b", "user-c", "user-d"}
+
+	pairs := PairAll(entities, userIDs, config)
+	var got []string
+	total := 0
+	for _, pair := range pairs {
+		got = append(got, pair.A.ID+"-"+pair.B.ID)
+		total += pair.Score
+	}
+	if want := []string{"a-b", "c-d"}; !reflect.DeepEqual(got, want) || total != 20 {
+		t.Errorf("PairAll = %v total %d, want %v total 20", got, total, want)
+	}
+}
+
+func TestImprovePairing(t *testing.T) {
+	weights := map[[2]int]int{{0, 1}: 3, {2, 3}: 3, {0, 2}: 5, {1, 3}: 5}
+	weight := func(i, j int) int {
+		if w, ok := weights[[2]int{min(i, j), max(i, j)}]; ok {
+			return w
+		}
+		return -1
+	}
+
+	// 交换两对的成员: (0,1)(2,3) 总分6 -> (0,2)(1,3) 总分10
+	mate := []int{1, 0, 3, 2}
+	if !improvePairing(mate, weight) {
+		t.Fatal("improvePairing found no improvement for swappable pairs")
+	}
+	if want := []int{2, 3, 0, 1}; !reflect.DeepEqual(mate, want) {
+		t.Errorf("mate = %v, want %v", mate, want)
+	}
+	if improvePairing(mate, weight) {
+		t.Errorf("improvePairing changed an optimal pairing to %v", mate)
+	}
+
+	// 两个空闲实体之间有边时直接配对
+	mate = []int{-1, -1, -1, -1}
+	if !improvePairing(mate, weight) || mate[0] != 1 || mate[1] != 0 {
+		t.Errorf("free entities not paired: mate = %v", mate)
+	}
+}
+
+func TestTagScore(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.TagMaxScore = 12
+	current := NewEntity("current", WithMicCount(3), WithTags("music", "gaming"))
+
+	tests := []struct {
+		name string
+		tags []string
+		want int16
+	}{
+		{"zero overlap", []string{"sports", "news"}, 0},
+		{"no tags", nil, 0},
+		{"partial", []string{"music", "chat"}, 4},          // 交集1 / 并集3
+		{"full", []string{"gaming", "music", "music"}, 12}, // 重复标签只计一次
+	}
+	for _, tt := range tests {
+		candidate := NewEntity("candidate", WithMicCount(3), WithTags(tt.tags...))
+		detail := ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &config, now)
+		if detail.Rejected || detail.TagScore != tt.want {
+			t.Errorf("%s: TagScore = %d (rejected %v), want %d", tt.name, detail.TagScore, detail.Rejected, tt.want)
+		}
+	}
+
+	// 上限为0时不启用
+	candidate := NewEntity("candidate", WithMicCount(3), WithTags("music", "gaming"))
+	if detail := ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &DefaultMatchConfig, now); detail.TagScore != 0 {
+		t.Errorf("TagScore with TagMaxScore 0 = %d, want 0", detail.TagScore)
+	}
+
+	var buf bytes.Buffer
+	detail := ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &config, now)
+	WriteMatchDetails(&buf, current, candidate, []*MatchDetail{detail})
+	if !strings.Contains(buf.String(), "标签得分: 12") {
+		t.Errorf("printed details missing tag score:\n%s", buf.String())
+	}
+}
+
+func TestMatchEngineSetConfigConcurrent(t *testing.T) {
+	open := DefaultMatchConfig
+	closed := DefaultMatchConfig
+	closed.MinMicCount = 10 // 池内候选全部因上麦人数不足被拒绝
+	engine, err := NewMatchEngine(&open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := NewEntity("current", WithMicCount(3))
+	pool := []*Entity{
+		NewEntity("room-1", WithMicCount(3), WithWaitSeconds(30)),
+		NewEntity("room-2", WithMicCount(3), WithWaitSeconds(90)),
+		NewEntity("room-3", WithMicCount(4), WithWaitSeconds(150)),
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			config := &open
+			if i%2 == 1 {
+				config = &closed
+			}
+			if err := engine.SetConfig(config); err != nil {
+				t.Error(err)
+				return
+			}
+			runtime.Gosched()
+		}
+	}()
+
+	var matchers sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		matchers.Add(1)
+		go func() {
+			defer matchers.Done()
+			for i := 0; i < 200; i++ {
+				_, details := engine.MatchDetailed(current, pool, "user-1")
+				// 同一次匹配只能看到一份配置: 要么全部因上麦人数被拒绝，要么都不是
+				low := 0
+				for _, detail := range details {
+					if detail.RejectCode == RejectMicCountTooLow {
+						low++
+					}
+				}
+				if low != 0 && low != len(details) {
+					t.Errorf("match saw mixed configs: %d of %d rejected for mic count", low, len(details))
+					return
+				}
+			}
+		}()
+	}
+	matchers.Wait()
+	close(done)
+	wg.Wait()
+}
+
+func TestScorePerspectives(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.InitiatorWeights = &ScoreWeights{WaitWeight: 2}
+	current := NewEntity("current", WithMicCount(3))
+	candidate := NewEntity("candidate", WithMicCount(3), WithWaitSeconds(120))
+
+	initiator := ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &config, now)
+	target := ScoreAs(PerspectiveTarget, current, candidate, "user-1", &config, now)
+	if initiator.Rejected || target.Rejected {
+		t.Fatalf("rejected: initiator %q, target %q", initiator.RejectReason, target.RejectReason)
+	}
+	// 子得分相同，只有权重不同: 发起方视角的等待得分计两次
+	if initiator.WaitScore != target.WaitScore || initiator.WaitScore == 0 {
+		t.Fatalf("WaitScore initiator %d, target %d", initiator.WaitScore, target.WaitScore)
+	}
+	if got, want := initiator.Score-target.Score, initiator.WaitScore; got != want {
+		t.Errorf("initiator %d - target %d = %d, want WaitScore %d", initiator.Score, target.Score, got, want)
+	}
+
+	// 未设置 InitiatorWeights 时两个视角得分相同
+	initiator = ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &DefaultMatchConfig, now)
+	target = ScoreAs(PerspectiveTarget, current, candidate, "user-1", &DefaultMatchConfig, now)
+	if initiator.Score != target.Score {
+		t.Errorf("default config: initiator %d, target %d", initiator.Score, target.Score)
+	}
+}
+
+func TestWriteDetailsCSV(t *testing.T) {
+	current := NewEntity("current")
+	details := []*MatchDetail{
+		{Entity: NewEntity("room-1"), Score: 42, RawScore: 42, WaitScore: 30, SegmentScore: 10, TagScore: 2, CurrentSegment: 1, CandidateSegment: 1},
+		{Entity: NewEntity("room-2"), Score: MinScore, Rejected: true, RejectCode: RejectCooldown, RejectReason: "冷却中, 仍需等待"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteDetailsCSV(&buf, current, details); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want header + 2 rows", len(records))
+	}
+	if !reflect.DeepEqual(records[0], detailCSVHeader) {
+		t.Errorf("header = %v", records[0])
+	}
+
+	// 按列名取值，含逗号的拒绝原因经 CSV 转义后原样还原
+	column := func(row []string, name string) string {
+		return row[slices.Index(records[0], name)]
+	}
+	want := map[string]string{
+		"current_id": "current", "entity_id": "room-1", "score": "42", "wait_score": "30",
+		"segment_score": "10", "tag_score": "2", "rejected": "false", "reject_code": RejectNone.String(),
+	}
+	for name, value := range want {
+		if got := column(records[1], name); got != value {
+			t.Errorf("row 1 %s = %q, want %q", name, got, value)
+		}
+	}
+	if got := column(records[2], "rejected"); got != "true" {
+		t.Errorf("row 2 rejected = %q", got)
+	}
+	if got := column(records[2], "reject_code"); got != RejectCooldown.String() {
+		t.Errorf("row 2 reject_code = %q", got)
+	}
+	if got := column(records[2], "reject_reason"); got != "冷却中, 仍需等待" {
+		t.Errorf("row 2 reject_reason = %q", got)
+	}
+}
+
+func TestMaxCandidatesScored(t *testing.T) {
+	const now = 1_700_000_000
+	current := NewEntity("current", WithMicCount(3))
+	var pool []*Entity
+	for i := 0; i < 10; i++ {
+		pool = append(pool, NewEntity(fmt.Sprintf("room-%d", i), WithMicCount(3), WithWaitSeconds(uint16(10*i))))
+	}
+
+	config := DefaultMatchConfig
+	config.MaxCandidatesScored = 4
+	engine, err := NewMatchEngine(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matched, details := engine.MatchAt(current, pool, "user-1", now)
+	if len(details) != 4 {
+		t.Fatalf("got %d details, want at most 4", len(details))
+	}
+	for i, detail := range details {
+		if detail.Entity != pool[i] {
+			t.Errorf("details[%d] = %s, want %s", i, detail.Entity.ID, pool[i].ID)
+		}
+	}
+	// 只在已打分的候选中选择，等待最久的 room-9 未被打分
+	if matched != pool[3] {
+		t.Errorf("matched %v, want room-3 (best of the first 4)", matched)
+	}
+
+	// GoodEnoughScore - 第一个达到分数线的候选之后不再打分
+	config.MaxCandidatesScored = 0
+	config.GoodEnoughScore = 1
+	if err := engine.SetConfig(&config); err != nil {
+		t.Fatal(err)
+	}
+	matched, details = engine.MatchAt(current, pool, "user-1", now)
+	if len(details) != 1 || matched != pool[0] {
+		t.Errorf("GoodEnoughScore: %d details, matched %v; want 1 detail and room-0", len(details), matched)
+	}
+}
+
+func BenchmarkMaxCandidatesScored(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	now := time.Now().Unix()
+	pool := GenerateEntityPoolAt(5000, r, now)
+	current := GenerateRandomEntityAt("current", r, now)
+
+	for _, limit := range []int{0, 500, 50} {
+		config := DefaultMatchConfig
+		config.MaxCandidatesScored = limit
+		engine, err := NewMatchEngineWithRand(&config, rand.New(rand.NewSource(1)))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("Limit%d", limit), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				engine.MatchAt(current, pool, "user-1", now)
+			}
+		})
+	}
+}
+
+func TestFreshnessScoreDecay(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.FreshnessMaxScore = 16
+	config.FreshnessHalfLife = 600
+	current := NewEntity("current", WithMicCount(3))
+
+	tests := []struct {
+		name       string
+		lastActive int64
+		want       int16
+	}{
+		{"just active", now, 16},
+		{"one half-life", now - 600, 8},
+		{"two half-lives", now - 1200, 4},
+		{"long idle", now - 6000, 0},
+		{"future timestamp", now + 3600, 16}, // 时钟偏差按刚活跃处理，不超过上限
+		{"unknown", 0, 0},
+	}
+	for _, tt := range tests {
+		candidate := NewEntity("candidate", WithMicCount(3), WithLastActive(tt.lastActive))
+		detail := ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &config, now)
+		if detail.Rejected || detail.FreshnessScore != tt.want {
+			t.Errorf("%s: FreshnessScore = %d (rejected %v), want %d", tt.name, detail.FreshnessScore, detail.Rejected, tt.want)
+		}
+	}
+}
+
+func TestDetailBufferCopy(t *testing.T) {
+	current := NewEntity("current", WithMicCount(3))
+	pool := []*Entity{NewEntity("room-1", WithMicCount(3), WithWaitSeconds(120))}
+
+	var buffer DetailBuffer
+	best, _ := buffer.Match(current, pool, "user-1", &DefaultMatchConfig)
+	if best == nil {
+		t.Fatal("no match")
+	}
+	kept := best.Copy()
+	buffer.Release()
+	// 回收后原详情被重置，复制出的详情不受影响
+	if best.Entity != nil || kept.Entity != pool[0] || kept.WaitScore == 0 {
+		t.Errorf("after Release: original entity %v, copy entity %v wait %d", best.Entity, kept.Entity, kept.WaitScore)
+	}
+}
+
+func BenchmarkDetailBuffer(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	now := time.Now().Unix()
+	pool := GenerateEntityPoolAt(1000, r, now)
+	current := GenerateRandomEntityAt("current", r, now)
+
+	b.Run("MatchAt", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			MatchAt(current, pool, "user-1", &DefaultMatchConfig, now)
+		}
+	})
+	b.Run("DetailBuffer", func(b *testing.B) {
+		b.ReportAllocs()
+		var buffer DetailBuffer
+		for i := 0; i < b.N; i++ {
+			buffer.Match(current, pool, "user-1", &DefaultMatchConfig)
+		}
+	})
+}
+
+func TestMatchSeq(t *testing.T) {
+	config := DefaultMatchConfig
+	config.TieBreak = TieBreakLowestID
+	current := NewEntity("current", WithMicCount(3), WithAudienceCount(20))
+	pool := []*Entity{
+		NewEntity("room-1", WithMicCount(3), WithAudienceCount(25), WithWaitSeconds(40)),
+		NewEntity("room-2", WithMicCount(8), WithWaitSeconds(300)),
+		NewEntity("room-3", WithMicCount(4), WithAudienceCount(18), WithWaitSeconds(130)),
+		NewEntity("room-4", WithMicCount(3), WithAudienceCount(19), WithWaitSeconds(130)),
+		NewEntity("room-5", WithMicCount(3), WithBlacklist("user-1"), WithWaitSeconds(500)),
+	}
+
+	want, _ := MatchAt(current, pool, "user-1", &config, time.Now().Unix())
+	if got := MatchSeq(current, slices.Values(pool), "user-1", &config); got != want {
+		t.Errorf("MatchSeq = %v, MatchAt = %v", got, want)
+	}
+
+	// 达到 MaxCandidatesScored 后停止迭代，不再拉取后续候选
+	config.MaxCandidatesScored = 2
+	pulled := 0
+	seq := func(yield func(*Entity) bool) {
+		for _, candidate := range pool {
+			pulled++
+			if !yield(candidate) {
+				return
+			}
+		}
+	}
+	want, _ = MatchAt(current, pool, "user-1", &config, time.Now().Unix())
+	if got := MatchSeq(current, seq, "user-1", &config); got != want {
+		t.Errorf("limited MatchSeq = %v, MatchAt = %v", got, want)
+	}
+	if pulled != 2 {
+		t.Errorf("pulled %d candidates, want 2", pulled)
+	}
+}
+
+func TestBareEntities(t *testing.T) {
+	const now = 1_700_000_000
+	// 未经 NewEntity 初始化的实体，所有 map 均为 nil
+	a := &Entity{ID: "a"}
+	b := &Entity{ID: "b"}
+	pool := []*Entity{a, b}
+
+	if outcome := MatchFull(a, pool, "user-a", &DefaultMatchConfig); outcome.Winner != b {
+		t.Fatalf("MatchFull(a) matched %v, want b", outcome.Winner)
+	}
+	if explanation := ExplainMatch(b, pool, "user-b", &DefaultMatchConfig); explanation == nil {
+		t.Fatal("ExplainMatch returned nil")
+	}
+	if matched := NewPool(pool...).Match(a, "user-a", &DefaultMatchConfig); matched != b {
+		t.Errorf("Pool.Match matched %v, want b", matched)
+	}
+	PairAll(pool, []string{"user-a", "user-b"}, &DefaultMatchConfig)
+
+	// 写入路径按需初始化 map
+	RecordMatch(a, b, "user-a", "user-b", now)
+	if a.LastMatchedUsers["user-b"] != now || b.LastMatchedUsers["user-a"] != now {
+		t.Errorf("RecordMatch: a %v, b %v", a.LastMatchedUsers, b.LastMatchedUsers)
+	}
+	b.BlockUser("user-c", now+60)
+	if !b.IsBlocked("user-c", now) {
+		t.Error("BlockUser on bare entity did not block")
+	}
+	if matched, details := MatchAt(a, pool, "user-a", &DefaultMatchConfig, now+1); matched != nil || details[1].RejectCode != RejectCooldown {
+		t.Errorf("after RecordMatch matched %v, code %v; want cooldown", matched, details[1].RejectCode)
+	}
+	c := &Entity{ID: "c"}
+	if pruned := c.PruneMatchHistory(now, DefaultMatchConfig.RecentMatchCooldown); pruned != 0 {
+		t.Errorf("PruneMatchHistory on bare entity pruned %d", pruned)
+	}
+	if clone := c.Clone(); !clone.Equal(c) {
+		t.Errorf("Clone of bare entity differs: %v", clone.Diff(c))
+	}
+}
+
+func TestClassifyMatch(t *testing.T) {
+	custom := DefaultMatchConfig
+	custom.QualityThresholds = QualityThresholds{Excellent: 40, Good: 20, Fair: 10}
+	normalized := DefaultMatchConfig
+	normalized.NormalizeScore = true
+
+	tests := []struct {
+		config *MatchConfig
+		score  int16
+		want   MatchQuality
+	}{
+		{&DefaultMatchConfig, 50, MatchQualityExcellent},
+		{&DefaultMatchConfig, 49, MatchQualityGood},
+		{&DefaultMatchConfig, 30, MatchQualityGood},
+		{&DefaultMatchConfig, 29, MatchQualityFair},
+		{&DefaultMatchConfig, 15, MatchQualityFair},
+		{&DefaultMatchConfig, 14, MatchQualityPoor},
+		{&DefaultMatchConfig, -20, MatchQualityPoor},
+		{&custom, 40, MatchQualityExcellent},
+		{&custom, 39, MatchQualityGood},
+		{&custom, 20, MatchQualityGood},
+		{&custom, 19, MatchQualityFair},
+		{&custom, 10, MatchQualityFair},
+		{&custom, 9, MatchQualityPoor},
+		{&normalized, 80, MatchQualityExcellent}, // 归一化后按0-100的默认分数线
+		{&normalized, 79, MatchQualityGood},
+		{&normalized, 40, MatchQualityFair},
+		{&normalized, 39, MatchQualityPoor},
+	}
+	for _, tt := range tests {
+		if got := ClassifyMatch(tt.score, tt.config); got != tt.want {
+			t.Errorf("ClassifyMatch(%d, %+v) = %v, want %v", tt.score, tt.config.qualityThresholds(), got, tt.want)
+		}
+	}
+
+	// MatchOutcome 上的质量与胜者得分一致，没有匹配时为 MatchQualityNone
+	current := NewEntity("current", WithMicCount(3))
+	pool := []*Entity{NewEntity("room-1", WithMicCount(3), WithWaitSeconds(200))}
+	if outcome := MatchFull(current, pool, "user-1", &DefaultMatchConfig); outcome.Winner == nil ||
+		outcome.Quality != ClassifyMatch(outcome.WinnerDetail.Score, &DefaultMatchConfig) {
+		t.Errorf("outcome quality %v for winner %v", outcome.Quality, outcome.Winner)
+	}
+	if outcome := MatchFull(current, nil, "user-1", &DefaultMatchConfig); outcome.Quality != MatchQualityNone {
+		t.Errorf("empty pool quality = %v, want none", outcome.Quality)
+	}
+}
+
+func TestMatchTiered(t *testing.T) {
+	current := NewEntity("current", WithMicCount(3))
+	premium := []*Entity{
+		NewEntity("premium-1", WithMicCount(3), WithBlacklist("user-1")),
+		NewEntity("premium-2", WithMicCount(3), WithAudienceCount(500)),
+	}
+	standard := []*Entity{NewEntity("standard-1", WithMicCount(3), WithWaitSeconds(60))}
+
+	config := DefaultMatchConfig
+	config.MaxAudienceCount = 100
+	config.FallbackStrategy = FallbackBestRejected
+	// 优先池全部被拒绝，且兜底只在最后一层生效，落到普通池
+	if got := MatchTiered(current, [][]*Entity{premium, standard}, "user-1", &config); got != standard[0] {
+		t.Errorf("MatchTiered = %v, want standard-1", got)
+	}
+	config.FallbackStrategy = FallbackNone
+	if got := MatchTiered(current, [][]*Entity{premium}, "user-1", &config); got != nil {
+		t.Errorf("MatchTiered with only rejects = %v, want nil", got)
+	}
+
+	// 优先池的候选低于该层最低分时继续尝试下一层
+	premium = []*Entity{NewEntity("premium-3", WithMicCount(3), WithWaitSeconds(10))}
+	standard = []*Entity{NewEntity("standard-2", WithMicCount(3), WithWaitSeconds(10))}
+	if got := MatchTiered(current, [][]*Entity{premium, standard}, "user-1", &config); got != premium[0] {
+		t.Errorf("MatchTiered without min scores = %v, want premium-3", got)
+	}
+	config.TierMinScores = []int16{math.MaxInt16}
+	if got := MatchTiered(current, [][]*Entity{premium, standard}, "user-1", &config); got != standard[0] {
+		t.Errorf("MatchTiered with premium min score = %v, want standard-2", got)
+	}
+}
+
+func TestAudienceBucketing(t *testing.T) {
+	const now = 1_700_000_000
+	bucketed := DefaultMatchConfig
+	bucketed.AudienceBucketSize = 10
+	current := NewEntity("current", WithMicCount(3), WithAudienceCount(50))
+	candidate := NewEntity("candidate", WithMicCount(3), WithAudienceCount(51))
+	same := NewEntity("same", WithMicCount(3), WithAudienceCount(50))
+
+	// 原始人数: 50 与 51 差1；分桶后同桶，差0
+	if diff := DefaultMatchConfig.audienceBucket(50) - DefaultMatchConfig.audienceBucket(51); diff != -1 {
+		t.Errorf("raw diff = %d, want -1", diff)
+	}
+	if diff := bucketed.audienceBucket(50) - bucketed.audienceBucket(51); diff != 0 {
+		t.Errorf("bucketed diff = %d, want 0", diff)
+	}
+
+	score := func(config *MatchConfig, candidate *Entity) int16 {
+		return ScoreAs(PerspectiveInitiator, current, candidate, "user-1", config, now).AudienceScore
+	}
+	scores := DefaultMatchConfig.audienceDiffScores()
+	if got := score(&DefaultMatchConfig, candidate); got != scores[1] {
+		t.Errorf("raw AudienceScore = %d, want %d", got, scores[1])
+	}
+	if got, want := score(&bucketed, candidate), score(&bucketed, same); got != want || got != scores[0] {
+		t.Errorf("bucketed AudienceScore = %d, same-count score %d, want both %d", got, want, scores[0])
+	}
+	// 跨桶时按桶下标之差打分: 50 与 61 相差一个桶
+	far := NewEntity("far", WithMicCount(3), WithAudienceCount(61))
+	if got := score(&bucketed, far); got != scores[1] {
+		t.Errorf("bucketed AudienceScore across one bucket = %d, want %d", got, scores[1])
+	}
+}
+
+func TestExplainPair(t *testing.T) {
+	const now = 1_700_000_000
+	a := NewEntity("room-a", WithMicCount(3))
+	b := NewEntity("room-b", WithMicCount(3), WithWaitSeconds(120), WithLastMatched("user-a", now-60))
+
+	detail := ExplainPair(a, b, "user-a", &DefaultMatchConfig, now)
+	if !detail.Rejected || detail.RejectCode != RejectCooldown || detail.Entity != b {
+		t.Fatalf("ExplainPair = rejected %v code %v entity %v, want cooldown on room-b", detail.Rejected, detail.RejectCode, detail.Entity)
+	}
+	// 解释模式下仍计算子得分，且不修改传入的配置
+	if detail.WaitScore == 0 || detail.RawScore == 0 {
+		t.Errorf("sub-scores missing: WaitScore %d, RawScore %d", detail.WaitScore, detail.RawScore)
+	}
+	if DefaultMatchConfig.ExplainMode {
+		t.Error("ExplainPair turned on ExplainMode in the caller's config")
+	}
+
+	// 冷却结束后同一对不再被拒绝
+	if detail := ExplainPair(a, b, "user-a", &DefaultMatchConfig, now+DefaultMatchConfig.RecentMatchCooldown); detail.Rejected {
+		t.Errorf("after cooldown: rejected with %v", detail.RejectCode)
+	}
+}
+
+func TestSegmentMapOverflow(t *testing.T) {
+	const now = 1_700_000_000
+	segments := &SegmentMap{Bounds: []uint16{1, 4, 7, 50}}
+	config := DefaultMatchConfig
+	config.SegmentMap = segments
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		mic  uint16
+		want uint8
+	}{{0, 0}, {1, 1}, {4, 2}, {16, 3}, {49, 3}, {50, 4}, {60, 4}} {
+		if got := segments.Segment(tt.mic); got != tt.want {
+			t.Errorf("Segment(%d) = %d, want %d", tt.mic, got, tt.want)
+		}
+	}
+	// 默认分段等价于 {1, 4, 7}
+	defaults := &SegmentMap{Bounds: []uint16{1, 4, 7}}
+	for mic := uint16(0); mic <= 80; mic++ {
+		if got, want := defaults.Segment(mic), getMicSegment(mic); got != want {
+			t.Errorf("default-equivalent Segment(%d) = %d, want %d", mic, got, want)
+		}
+	}
+
+	huge := NewEntity("huge", WithMicCount(60))
+	peer := NewEntity("peer", WithMicCount(55))
+	large := NewEntity("large", WithMicCount(20), WithWaitSeconds(120))
+	detail := ScoreAs(PerspectiveInitiator, huge, peer, "user-1", &config, now)
+	if detail.Rejected || detail.CurrentSegment != 4 || detail.CandidateSegment != 4 || detail.SegmentScore != 10 {
+		t.Errorf("60 vs 55 mic: segments %d/%d score %d rejected %v", detail.CurrentSegment, detail.CandidateSegment, detail.SegmentScore, detail.Rejected)
+	}
+	// 相邻段位等待足够时仍可匹配；隔离溢出段位后只与同段位匹配
+	if detail := ScoreAs(PerspectiveInitiator, huge, large, "user-1", &config, now); detail.Rejected || detail.SegmentScore != 3 {
+		t.Errorf("60 vs 20 mic: score %d rejected %v (%s)", detail.SegmentScore, detail.Rejected, detail.RejectReason)
+	}
+	config.SegmentMap = &SegmentMap{Bounds: segments.Bounds, IsolateOverflow: true}
+	if detail := ScoreAs(PerspectiveInitiator, huge, large, "user-1", &config, now); detail.RejectCode != RejectStrictSegment {
+		t.Errorf("isolated overflow: code %v, want strict segment", detail.RejectCode)
+	}
+	if detail := ScoreAs(PerspectiveInitiator, huge, peer, "user-1", &config, now); detail.Rejected {
+		t.Errorf("isolated overflow rejected a same-segment peer: %s", detail.RejectReason)
+	}
+}
+
+func TestEntityEqualAndDiff(t *testing.T) {
+	original := NewEntity("room-1", WithMicCount(3), WithAudienceCount(20), WithBlacklist("user-2"), WithLastMatched("user-3", 100))
+
+	// 相等: 深拷贝、nil 与空 map、段位缓存不同
+	same := original.Clone()
+	same.Segment()
+	if !original.Equal(same) || original.Diff(same) != nil {
+		t.Errorf("clone differs: %v", original.Diff(same))
+	}
+	bare, empty := &Entity{ID: "x"}, NewEntity("x")
+	if !bare.Equal(empty) {
+		t.Errorf("nil maps vs empty maps differ: %v", bare.Diff(empty))
+	}
+	var none *Entity
+	if !none.Equal(nil) || none.Equal(original) || original.Equal(nil) {
+		t.Error("nil entity comparison wrong")
+	}
+
+	// 标量字段不同
+	scalar := original.Clone()
+	scalar.AudienceCount++
+	scalar.Region = "eu"
+	if original.Equal(scalar) {
+		t.Error("scalar change not detected")
+	}
+	if got, want := original.Diff(scalar), []string{"Region", "AudienceCount"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %v, want %v", got, want)
+	}
+
+	// map 字段不同: 同样大小但内容不同
+	mapped := original.Clone()
+	delete(mapped.Blacklist, "user-2")
+	mapped.Blacklist["user-4"] = struct{}{}
+	mapped.LastMatchedUsers["user-3"] = 200
+	if got, want := original.Diff(mapped), []string{"LastMatchedUsers", "Blacklist"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %v, want %v", got, want)
+	}
+}
+
+func TestMergePools(t *testing.T) {
+	a1 := NewEntity("a", WithWaitSeconds(10))
+	a2 := NewEntity("a", WithWaitSeconds(90))
+	b := NewEntity("b")
+	c1 := NewEntity("c", WithWaitSeconds(50))
+	c2 := NewEntity("c", WithWaitSeconds(20))
+	d := NewEntity("d")
+
+	merged := MergePools([]*Entity{a1, b, c1}, []*Entity{c2, nil, d, a2})
+	ids := make([]string, len(merged))
+	for i, entity := range merged {
+		ids[i] = entity.ID
+	}
+	// ID 唯一，位置取第一次出现的位置，保留最后出现的实体
+	if want := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("merged IDs = %v, want %v", ids, want)
+	}
+	if merged[0] != a2 || merged[2] != c2 {
+		t.Errorf("MergePools kept %v/%v, want the last occurrences", merged[0].WaitSeconds, merged[2].WaitSeconds)
+	}
+
+	// 自定义解决方式: 保留等待时间更长的实体
+	longest := func(kept, next *Entity) *Entity {
+		if next.WaitSeconds > kept.WaitSeconds {
+			return next
+		}
+		return kept
+	}
+	merged = MergePoolsFunc(longest, []*Entity{a1, b, c1}, []*Entity{c2, d, a2})
+	if merged[0] != a2 || merged[2] != c1 {
+		t.Errorf("MergePoolsFunc kept a wait %d, c wait %d; want 90 and 50", merged[0].WaitSeconds, merged[2].WaitSeconds)
+	}
+	if len(MergePools()) != 0 {
+		t.Error("MergePools() not empty")
+	}
+}
+
+func TestLoadMatchConfig(t *testing.T) {
+	defaultScores := slices.Clone(DefaultMatchConfig.AudienceDiffScores)
+	partial := `{
+		"min_mic_count": 2,
+		"audience_diff_scores": [9, 4],
+		"weights": {"wait_weight": 2}
+	}`
+	config, err := LoadMatchConfig(strings.NewReader(partial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MinMicCount != 2 || !slices.Equal(config.AudienceDiffScores, []int16{9, 4}) || config.Weights.WaitWeight != 2 {
+		t.Errorf("loaded fields: MinMicCount %d, AudienceDiffScores %v, WaitWeight %v", config.MinMicCount, config.AudienceDiffScores, config.Weights.WaitWeight)
+	}
+	// 未出现的字段沿用默认值，包括同一结构体内未出现的权重
+	if config.RecentMatchCooldown != DefaultMatchConfig.RecentMatchCooldown || config.MaxWaitTime != DefaultMatchConfig.MaxWaitTime {
+		t.Errorf("defaults not kept: cooldown %d, max wait %d", config.RecentMatchCooldown, config.MaxWaitTime)
+	}
+	if config.Weights.SegmentWeight != DefaultScoreWeights.SegmentWeight {
+		t.Errorf("SegmentWeight = %v, want default %v", config.Weights.SegmentWeight, DefaultScoreWeights.SegmentWeight)
+	}
+	// 加载不改写包级默认配置
+	if !slices.Equal(DefaultMatchConfig.AudienceDiffScores, defaultScores) {
+		t.Errorf("DefaultMatchConfig.AudienceDiffScores changed to %v", DefaultMatchConfig.AudienceDiffScores)
+	}
+
+	for name, input := range map[string]string{
+		"unknown field": `{"min_mic_cuont": 2}`,
+		"invalid value": `{"recent_match_cooldown": -1}`,
+		"malformed":     `{"min_mic_count": `,
+	} {
+		if _, err := LoadMatchConfig(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: LoadMatchConfig accepted %s", name, input)
+		}
+	}
+}
+
+// 固定得分的打分器
+type constScorer int16
+
+func (s constScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	return int16(s), false, ""
+}
+
+func TestScoreSaturatesInsteadOfOverflowing(t *testing.T) {
+	const now = 1_700_000_000
+	current := NewEntity("current", WithMicCount(3), WithLanguage("zh"))
+	candidate := NewEntity("candidate", WithMicCount(3), WithLanguage("zh"), WithWaitSeconds(300), WithActivity(ActivityHigh))
+
+	// 各项权重很大时加权和远超 int16，直接按 int16 相加会回绕成负数
+	config := DefaultMatchConfig
+	config.Weights = ScoreWeights{WaitWeight: 1000, SegmentWeight: 1000, AudienceWeight: 1000, HistoryWeight: 1000, ActivityWeight: 1000, LanguageWeight: 1000}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	detail := ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &config, now)
+	if detail.Rejected || detail.Score != math.MaxInt16 || detail.RawScore != math.MaxInt16 {
+		t.Errorf("high weights: Score %d RawScore %d rejected %v, want saturated at %d", detail.Score, detail.RawScore, detail.Rejected, math.MaxInt16)
+	}
+
+	// 多个自定义打分器的负分之和饱和到 minValidScore，不与拒绝哨兵值 MinScore 混淆
+	config = DefaultMatchConfig
+	config.Scorers = []Scorer{constScorer(-30000), constScorer(-30000), constScorer(-30000)}
+	detail = ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &config, now)
+	if detail.Rejected || detail.Score != minValidScore {
+		t.Errorf("large negative sum: Score %d rejected %v, want %d", detail.Score, detail.Rejected, minValidScore)
+	}
+	if err := detail.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplayJSONRoundTrip(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.MinMicCount = 2
+	config.AudienceBucketSize = 10
+	current := NewEntity("current", WithMicCount(3), WithAudienceCount(20))
+	var pool []*Entity
+	for i := 0; i < 12; i++ {
+		// 同分候选很多，胜者取决于随机种子
+		pool = append(pool, NewEntity(fmt.Sprintf("room-%02d", i), WithMicCount(3), WithAudienceCount(uint16(20+i%5)), WithWaitSeconds(130)))
+	}
+	pool = append(pool, NewEntity("room-low", WithMicCount(1), WithWaitSeconds(300)))
+
+	for seed := int64(1); seed <= 5; seed++ {
+		engine, err := NewMatchEngineWithRand(&config, rand.New(rand.NewSource(seed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorded, recordedDetails := engine.MatchAt(current, pool, "user-1", now)
+		if recorded == nil {
+			t.Fatal("recorded match found nothing")
+		}
+
+		data, err := json.Marshal(ReplayInput{Current: current, Pool: pool, UserID: "user-1", Config: &config, Now: now, Seed: seed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var input ReplayInput
+		if err := json.Unmarshal(data, &input); err != nil {
+			t.Fatal(err)
+		}
+		outcome := Replay(input)
+		if outcome.Winner == nil || outcome.Winner.ID != recorded.ID {
+			t.Errorf("seed %d: replay winner %v, recorded %s", seed, outcome.Winner, recorded.ID)
+			continue
+		}
+		for i, detail := range outcome.AllDetails {
+			if detail.Score != recordedDetails[i].Score || detail.RejectCode != recordedDetails[i].RejectCode {
+				t.Errorf("seed %d: detail %d replayed as %d/%v, recorded %d/%v", seed, i,
+					detail.Score, detail.RejectCode, recordedDetails[i].Score, recordedDetails[i].RejectCode)
+			}
+		}
+	}
+}
+
+func TestSegmentDistanceScores(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.SegmentDistanceScores = []SegmentDistanceScore{
+		{Score: 10},              // 同段位
+		{Score: 3, MinWait: 60},  // 相邻段位，与默认值一致
+		{Score: 1, MinWait: 180}, // 相差两段，等待足够久后给低分
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	current := NewEntity("current", WithMicCount(1)) // 段位1
+
+	tests := []struct {
+		name  string
+		mic   uint16
+		wait  uint16
+		score int16
+		code  RejectCode
+	}{
+		{"distance 0", 2, 0, 10, RejectNone},
+		{"distance 1 with wait", 5, 60, 3, RejectNone},
+		{"distance 2 with wait", 8, 180, 1, RejectNone},
+		{"distance 2 without wait", 8, 179, 0, RejectSegmentGap},
+	}
+	for _, tt := range tests {
+		candidate := NewEntity("candidate", WithMicCount(tt.mic), WithWaitSeconds(tt.wait))
+		detail := ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &config, now)
+		if detail.RejectCode != tt.code || !detail.Rejected && detail.SegmentScore != tt.score {
+			t.Errorf("%s: SegmentScore %d code %v, want %d %v", tt.name, detail.SegmentScore, detail.RejectCode, tt.score, tt.code)
+		}
+	}
+
+	// 超出表长的距离一律拒绝: 段位0与段位3相差三段
+	far := NewEntity("far", WithMicCount(0), WithWaitSeconds(600))
+	candidate := NewEntity("candidate", WithMicCount(8), WithWaitSeconds(600))
+	if detail := ScoreAs(PerspectiveInitiator, far, candidate, "user-1", &config, now); detail.RejectCode != RejectSegmentGap {
+		t.Errorf("distance 3: code %v, want segment gap", detail.RejectCode)
+	}
+
+	// 默认配置下相差两段在等待足够时得0分而非1分
+	candidate = NewEntity("candidate", WithMicCount(8), WithWaitSeconds(180))
+	if detail := ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &DefaultMatchConfig, now); detail.Rejected || detail.SegmentScore != 0 {
+		t.Errorf("default distance 2: SegmentScore %d rejected %v", detail.SegmentScore, detail.Rejected)
+	}
+}
+
+func TestTieBreakLowestID(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.TieBreak = TieBreakLowestID
+	current := NewEntity("current", WithMicCount(3))
+	pool := []*Entity{
+		NewEntity("room-m", WithMicCount(3), WithWaitSeconds(130)),
+		NewEntity("room-b", WithMicCount(3), WithWaitSeconds(130)),
+		NewEntity("room-a", WithMicCount(3), WithWaitSeconds(30)), // 得分较低，ID 最小也不应被选中
+		NewEntity("room-k", WithMicCount(3), WithWaitSeconds(130)),
+		NewEntity("room-c", WithMicCount(3), WithWaitSeconds(130), WithBlacklist("user-1")),
+	}
+
+	// 不使用随机数
+	intn := func(int) int {
+		t.Fatal("TieBreakLowestID used rand")
+		return 0
+	}
+	for i := 0; i < 3; i++ {
+		selected, _ := matchDetailedWith(current, pool, "user-1", &config, now, intn)
+		if selected == nil || selected.Entity.ID != "room-b" {
+			t.Fatalf("selected %v, want room-b", selected.entity())
+		}
+		slices.Reverse(pool) // 与候选顺序无关
+	}
+}
+
+func TestOnScoredHook(t *testing.T) {
+	const now = 1_700_000_000
+	current := NewEntity("current", WithMicCount(3))
+	pool := []*Entity{
+		NewEntity("room-1", WithMicCount(3), WithWaitSeconds(130)),
+		NewEntity("room-2", WithMicCount(3), WithBlacklist("user-1")),
+		NewEntity("room-3", WithMicCount(3), WithWaitSeconds(40)),
+		NewEntity("room-4", WithMicCount(9)),
+	}
+
+	calls := 0
+	config := DefaultMatchConfig
+	config.OnScored = func(detail *MatchDetail) {
+		calls++
+		// 修改副本不影响匹配结果
+		detail.Score = math.MaxInt16
+		detail.Rejected = false
+	}
+	matched, details := MatchAt(current, pool, "user-1", &config, now)
+	if calls != len(pool) {
+		t.Errorf("OnScored called %d times, want %d", calls, len(pool))
+	}
+	if matched != pool[0] {
+		t.Errorf("matched %v, want room-1", matched)
+	}
+	if !details[1].Rejected || details[0].Score == math.MaxInt16 {
+		t.Errorf("hook mutated details: %+v, %+v", *details[0], *details[1])
+	}
+}
+
+func TestTimeOfDayScore(t *testing.T) {
+	const now = 1_700_000_000 // UTC 22:13，东八区次日 06:13
+	config := DefaultMatchConfig
+	config.TimeOfDayBonus = 7
+	current := NewEntity("current", WithMicCount(3))
+
+	tests := []struct {
+		name   string
+		hours  []int
+		offset int
+		want   int16
+	}{
+		{"in range", []int{20, 21, 22}, 0, 7},
+		{"out of range", []int{9, 23}, 0, 0},
+		{"no preference", nil, 0, 0},
+		{"in range with offset", []int{6}, 8 * 3600, 7},
+		{"out of range with offset", []int{22}, 8 * 3600, 0},
+	}
+	for _, tt := range tests {
+		config.TimeOfDayOffset = tt.offset
+		candidate := NewEntity("candidate", WithMicCount(3), WithPreferredHours(tt.hours...))
+		detail := ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &config, now)
+		if detail.Rejected || detail.TimeOfDayScore != tt.want {
+			t.Errorf("%s: TimeOfDayScore = %d (rejected %v), want %d", tt.name, detail.TimeOfDayScore, detail.Rejected, tt.want)
+		}
+	}
+
+	// 整点边界: 22:59:59 仍在22点，23:00:00 已不在
+	candidate := NewEntity("candidate", WithMicCount(3), WithPreferredHours(22))
+	config.TimeOfDayOffset = 0
+	const hourStart = now - 13*60 - 20 // 22:00:00
+	if got := ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &config, hourStart+3599).TimeOfDayScore; got != 7 {
+		t.Errorf("22:59:59 score = %d, want 7", got)
+	}
+	if got := ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &config, hourStart+3600).TimeOfDayScore; got != 0 {
+		t.Errorf("23:00:00 score = %d, want 0", got)
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	pool := []*Entity{
+		NewEntity("room-1", WithMicCount(0), WithWaitSeconds(10)),
+		NewEntity("room-2", WithMicCount(2), WithWaitSeconds(20), WithActivity(ActivityHigh), WithBlacklist("u1", "u2")),
+		NewEntity("room-3", WithMicCount(3), WithWaitSeconds(30), WithActivity(ActivityMedium)),
+		nil,
+		NewEntity("room-4", WithMicCount(8), WithWaitSeconds(40), WithBlacklist("u3")),
+		NewEntity("room-5", WithMicCount(40), WithWaitSeconds(100), WithActivity(ActivityHigh)),
+	}
+
+	summary := PoolStats(pool)
+	if want := map[uint8]int{0: 1, 1: 2, 3: 2}; !maps.Equal(summary.SegmentCounts, want) {
+		t.Errorf("SegmentCounts = %v, want %v", summary.SegmentCounts, want)
+	}
+	if want := map[ActivityLevel]int{ActivityLow: 2, ActivityMedium: 1, ActivityHigh: 2}; !maps.Equal(summary.ActivityCounts, want) {
+		t.Errorf("ActivityCounts = %v, want %v", summary.ActivityCounts, want)
+	}
+	if summary.Total != 5 || summary.AverageWait != 40 || summary.BlacklistDensity != 0.6 || summary.WithBlacklist != 2 {
+		t.Errorf("summary = %+v", summary)
+	}
+
+	if empty := PoolStats(nil); empty.Total != 0 || empty.AverageWait != 0 || len(empty.SegmentCounts) != 0 {
+		t.Errorf("empty pool summary = %+v", empty)
+	}
+}
+
+func TestTieBreakLongestWait(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.TieBreak = TieBreakLongestWait
+	current := NewEntity("current", WithMicCount(3))
+	// 131 与 139 秒落在同一个10秒分段，得分相同
+	pool := []*Entity{
+		NewEntity("room-a", WithMicCount(3), WithWaitSeconds(131)),
+		NewEntity("room-b", WithMicCount(3), WithWaitSeconds(139)),
+	}
+
+	noRand := func(int) int {
+		t.Fatal("TieBreakLongestWait used rand with a single longest wait")
+		return 0
+	}
+	selected, details := matchDetailedWith(current, pool, "user-1", &config, now, noRand)
+	if details[0].Score != details[1].Score {
+		t.Fatalf("candidates not tied: %d vs %d", details[0].Score, details[1].Score)
+	}
+	if selected.entity() != pool[1] {
+		t.Errorf("selected %v, want room-b (waited longest)", selected.entity())
+	}
+
+	// 等待时间也相同时才随机选择
+	pool = append(pool, NewEntity("room-c", WithMicCount(3), WithWaitSeconds(139)))
+	calls := 0
+	pickLast := func(n int) int {
+		calls++
+		if n != 2 {
+			t.Errorf("random pick among %d, want 2", n)
+		}
+		return n - 1
+	}
+	if selected, _ := matchDetailedWith(current, pool, "user-1", &config, now, pickLast); calls != 1 || selected.entity() != pool[2] {
+		t.Errorf("selected %v after %d rand calls, want room-c after 1", selected.entity(), calls)
+	}
+}
+
+func TestGroupRejections(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.MinMicCount = 2
+	current := NewEntity("current", WithMicCount(3))
+	pool := []*Entity{
+		NewEntity("ok-1", WithMicCount(3), WithWaitSeconds(130)),
+		NewEntity("blocked-1", WithMicCount(3), WithBlacklist("user-1")),
+		NewEntity("cold-1", WithMicCount(3), WithLastMatched("user-1", now-10)),
+		NewEntity("quiet-1", WithMicCount(1)),
+		NewEntity("blocked-2", WithMicCount(4), WithBlacklist("user-1")),
+		NewEntity("ok-2", WithMicCount(3)),
+	}
+
+	_, details := MatchAt(current, pool, "user-1", &config, now)
+	groups := GroupRejections(details)
+	want := map[RejectCode][]string{
+		RejectBlacklisted:    {"blocked-1", "blocked-2"},
+		RejectCooldown:       {"cold-1"},
+		RejectMicCountTooLow: {"quiet-1"},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("GroupRejections = %v, want %v", groups, want)
+	}
+	if groups := GroupRejections(nil); len(groups) != 0 {
+		t.Errorf("GroupRejections(nil) = %v", groups)
+	}
+}
+
+func TestBoostScore(t *testing.T) {
+	const now = 1_700_000_000
+	current := NewEntity("current", WithMicCount(3))
+	plain := NewEntity("plain", WithMicCount(3), WithWaitSeconds(130))
+
+	tests := []struct {
+		name      string
+		candidate *Entity
+		want      int16
+	}{
+		{"active", NewEntity("active", WithMicCount(3), WithWaitSeconds(130), WithBoost(20, now+60)), 20},
+		{"last second", NewEntity("last", WithMicCount(3), WithWaitSeconds(130), WithBoost(20, now+1)), 20},
+		{"expired at until", NewEntity("expired", WithMicCount(3), WithWaitSeconds(130), WithBoost(20, now)), 0},
+		{"expired", NewEntity("old", WithMicCount(3), WithWaitSeconds(130), WithBoost(20, now-3600)), 0},
+		{"no boost", plain, 0},
+	}
+	base := ScoreAs(PerspectiveInitiator, current, plain, "user-1", &DefaultMatchConfig, now).Score
+	for _, tt := range tests {
+		detail := ScoreAs(PerspectiveInitiator, current, tt.candidate, "user-1", &DefaultMatchConfig, now)
+		if detail.BoostScore != tt.want || detail.Score != base+tt.want {
+			t.Errorf("%s: BoostScore %d Score %d, want %d and %d", tt.name, detail.BoostScore, detail.Score, tt.want, base+tt.want)
+		}
+	}
+
+	// 推广中的候选胜过其他条件相同的候选
+	boosted := tests[0].candidate
+	if matched, _ := MatchAt(current, []*Entity{plain, boosted}, "user-1", &DefaultMatchConfig, now); matched != boosted {
+		t.Errorf("matched %v, want the boosted room", matched)
+	}
+}
+
+func TestMatchSticky(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.StickinessBonus = 5
+	current := NewEntity("current", WithMicCount(3))
+	previous := NewEntity("previous", WithMicCount(3), WithWaitSeconds(131))
+	marginal := NewEntity("marginal", WithMicCount(3), WithWaitSeconds(151)) // 等待得分高4分
+	pool := []*Entity{previous, marginal}
+
+	// 没有上一次结果时分数略高的新候选胜出
+	matched, details, err := MatchSticky(current, pool, "user-1", &config, now, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matched != marginal || details[1].Score-details[0].Score != 4 {
+		t.Fatalf("without previous: matched %v, scores %d/%d", matched, details[0].Score, details[1].Score)
+	}
+	// 差距在粘性加分以内时保留上一次的结果
+	matched, details, _ = MatchSticky(current, pool, "user-1", &config, now, "previous")
+	if matched != previous || details[0].StickinessScore != 5 || details[1].StickinessScore != 0 {
+		t.Errorf("with previous: matched %v, stickiness %d/%d", matched, details[0].StickinessScore, details[1].StickinessScore)
+	}
+	// 明显更好的候选仍会替换
+	better := NewEntity("better", WithMicCount(3), WithWaitSeconds(200))
+	if matched, _, _ := MatchSticky(current, []*Entity{previous, better}, "user-1", &config, now, "previous"); matched != better {
+		t.Errorf("clearly better candidate: matched %v, want better", matched)
+	}
+	// 上一次的结果被拒绝时不加分
+	cold := NewEntity("previous", WithMicCount(3), WithWaitSeconds(131), WithLastMatched("user-1", now-10))
+	if matched, _, _ := MatchSticky(current, []*Entity{cold, marginal}, "user-1", &config, now, "previous"); matched != marginal {
+		t.Errorf("rejected previous: matched %v, want marginal", matched)
+	}
+
+	// 粘性无法生效时返回错误，而不是悄悄退化为普通匹配
+	noBonus := config.With(func(c *MatchConfig) { c.StickinessBonus = 0 })
+	if _, _, err := MatchSticky(current, pool, "user-1", noBonus, now, "previous"); err == nil {
+		t.Error("MatchSticky accepted a zero StickinessBonus")
+	}
+	custom := config.With(func(c *MatchConfig) { c.Scorers = []Scorer{SegmentScorer{}, WaitScorer{}} })
+	if _, _, err := MatchSticky(current, pool, "user-1", custom, now, "previous"); err == nil {
+		t.Error("MatchSticky accepted custom Scorers without StickinessScorer")
+	}
+	if _, _, err := MatchSticky(current, pool, "user-1", noBonus, now, ""); err != nil {
+		t.Errorf("MatchSticky without previousID: %v", err)
+	}
+}
+
+func TestFillScore(t *testing.T) {
+	const now = 1_700_000_000
+	config := DefaultMatchConfig
+	config.TargetAudience = 40
+	config.FillMaxScore = 8
+	current := NewEntity("current", WithMicCount(3))
+
+	tests := []struct {
+		audience    uint16
+		want        int16
+		wantPenalty int16 // FillOverPenalty 开启时
+	}{
+		{0, 8, 8},
+		{10, 6, 6},
+		{20, 4, 4},
+		{30, 2, 2},
+		{40, 0, 0},
+		{60, 0, -4},
+		{200, 0, -8}, // 扣分不超过 FillMaxScore
+	}
+	for _, tt := range tests {
+		candidate := NewEntity("candidate", WithMicCount(3), WithAudienceCount(tt.audience))
+		config.FillOverPenalty = false
+		if got := ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &config, now).FillScore; got != tt.want {
+			t.Errorf("audience %d: FillScore = %d, want %d", tt.audience, got, tt.want)
+		}
+		config.FillOverPenalty = true
+		if got := ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &config, now).FillScore; got != tt.wantPenalty {
+			t.Errorf("audience %d with over penalty: FillScore = %d, want %d", tt.audience, got, tt.wantPenalty)
+		}
+	}
+
+	// 未设置目标时不启用
+	candidate := NewEntity("candidate", WithMicCount(3))
+	if got := ScoreAs(PerspectiveInitiator, current, candidate, "user-1", &DefaultMatchConfig, now).FillScore; got != 0 {
+		t.Errorf("default config FillScore = %d, want 0", got)
+	}
+}
@@ -0,0 +1,235 @@
+package matchroom
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// 匹配统计 - 汇总一次匹配的候选数量和拒绝原因，便于导出监控指标
+type MatchStats struct {
+	TotalCandidates int                // 总候选数
+	RejectedCount   int                // 被拒绝数
+	ValidCount      int                // 有效候选数
+	RejectReasons   map[string]int     // 拒绝原因: 次数
+	RejectCodes     map[RejectCode]int // 拒绝码: 次数
+}
+
+// 统计匹配详情
+func NewMatchStats(details []*MatchDetail) MatchStats {
+	stats := MatchStats{
+		TotalCandidates: len(details),
+		RejectReasons:   make(map[string]int),
+		RejectCodes:     make(map[RejectCode]int),
+	}
+	for _, detail := range details {
+		if detail.Rejected {
+			stats.RejectedCount++
+			stats.RejectReasons[detail.RejectReason]++
+			stats.RejectCodes[detail.RejectCode]++
+		} else {
+			stats.ValidCount++
+		}
+	}
+	return stats
+}
+
+// 按拒绝码分组 - 拒绝码: 被拒绝的候选ID，ID按详情顺序排列，未被拒绝的候选不出现
+func GroupRejections(details []*MatchDetail) map[RejectCode][]string {
+	groups := make(map[RejectCode][]string)
+	for _, detail := range details {
+		if detail.Rejected && detail.Entity != nil {
+			groups[detail.RejectCode] = append(groups[detail.RejectCode], detail.Entity.ID)
+		}
+	}
+	return groups
+}
+
+// 拒绝率 - 没有候选时返回0
+func (s MatchStats) RejectionRate() float64 {
+	if s.TotalCandidates == 0 {
+		return 0
+	}
+	return float64(s.RejectedCount) / float64(s.TotalCandidates)
+}
+
+// 候选池概况 - 匹配前查看候选池构成，用于排查匹配失败的原因（如全部集中在同一段位）
+type PoolSummary struct {
+	Total            int                   // 实体总数，nil 实体不计入
+	SegmentCounts    map[uint8]int         // 段位: 实体数，按默认分段统计
+	ActivityCounts   map[ActivityLevel]int // 活跃度等级: 实体数
+	AverageWait      float64               // 平均等待时间（秒）
+	BlacklistDensity float64               // 平均每个实体的黑名单人数
+	WithBlacklist    int                   // 黑名单非空的实体数
+}
+
+// 统计候选池
+func PoolStats(pool []*Entity) PoolSummary {
+	summary := PoolSummary{
+		SegmentCounts:  make(map[uint8]int),
+		ActivityCounts: make(map[ActivityLevel]int),
+	}
+	totalWait, totalBlacklist := 0, 0
+	for _, entity := range pool {
+		if entity == nil {
+			continue
+		}
+		summary.Total++
+		summary.SegmentCounts[entity.Segment()]++
+		summary.ActivityCounts[entity.ActivityLevel]++
+		totalWait += int(entity.WaitSeconds)
+		totalBlacklist += len(entity.Blacklist)
+		if len(entity.Blacklist) > 0 {
+			summary.WithBlacklist++
+		}
+	}
+	if summary.Total > 0 {
+		summary.AverageWait = float64(totalWait) / float64(summary.Total)
+		summary.BlacklistDensity = float64(totalBlacklist) / float64(summary.Total)
+	}
+	return summary
+}
+
+// 匹配结果 - 汇总选中的实体、其打分详情、所有候选详情及统计信息
+type MatchOutcome struct {
+	Winner       *Entity        // 选中的实体，没有匹配时为 nil
+	WinnerDetail *MatchDetail   // 选中实体的打分详情
+	AllDetails   []*MatchDetail // 所有候选的打分详情
+	Stats        MatchStats     // 候选数量及拒绝原因统计
+	Quality      MatchQuality   // 选中实体的匹配质量，没有匹配时为 MatchQualityNone
+}
+
+// 构造匹配结果
+func newMatchOutcome(selected *MatchDetail, details []*MatchDetail, config *MatchConfig) *MatchOutcome {
+	outcome := &MatchOutcome{
+		Winner:       selected.entity(),
+		WinnerDetail: selected,
+		AllDetails:   details,
+		Stats:        NewMatchStats(details),
+	}
+	if selected != nil {
+		outcome.Quality = ClassifyMatch(selected.Score, config)
+	}
+	return outcome
+}
+
+// 匹配质量等级 - 由选中实体的分数档位得出，用于界面文案
+type MatchQuality uint8
+
+const (
+	MatchQualityNone      MatchQuality = iota // 没有匹配
+	MatchQualityPoor                          // 较差
+	MatchQualityFair                          // 一般
+	MatchQualityGood                          // 良好
+	MatchQualityExcellent                     // 极佳
+)
+
+var matchQualityNames = [...]string{
+	MatchQualityNone:      "none",
+	MatchQualityPoor:      "poor",
+	MatchQualityFair:      "fair",
+	MatchQualityGood:      "good",
+	MatchQualityExcellent: "excellent",
+}
+
+// 辅助函数：转换匹配质量为字符串
+func (q MatchQuality) String() string {
+	if int(q) < len(matchQualityNames) {
+		return matchQualityNames[q]
+	}
+	return fmt.Sprintf("match_quality_%d", uint8(q))
+}
+
+// 文本序列化 - JSON 中以名称输出匹配质量
+func (q MatchQuality) MarshalText() ([]byte, error) {
+	return []byte(q.String()), nil
+}
+
+// 匹配质量分数线 - 分数不低于某一档的分数线即属于该档，低于 Fair 为 Poor
+type QualityThresholds struct {
+	Excellent int16 `json:"excellent"`
+	Good      int16 `json:"good"`
+	Fair      int16 `json:"fair"`
+}
+
+// 默认分数线 - 原始分按默认配置的理论满分79设定，归一化分按0-100设定
+var (
+	DefaultQualityThresholds           = QualityThresholds{Excellent: 50, Good: 30, Fair: 15}
+	DefaultNormalizedQualityThresholds = QualityThresholds{Excellent: 80, Good: 60, Fair: 40}
+)
+
+// 分数线解析 - 未设置时按是否归一化选用默认分数线
+func (c *MatchConfig) qualityThresholds() QualityThresholds {
+	if c.QualityThresholds != (QualityThresholds{}) {
+		return c.QualityThresholds
+	}
+	if c.NormalizeScore {
+		return DefaultNormalizedQualityThresholds
+	}
+	return DefaultQualityThresholds
+}
+
+// 匹配质量分类 - 按 MatchConfig.QualityThresholds 将分数划入 Excellent/Good/Fair/Poor
+func ClassifyMatch(score int16, config *MatchConfig) MatchQuality {
+	thresholds := config.qualityThresholds()
+	switch {
+	case score >= thresholds.Excellent:
+		return MatchQualityExcellent
+	case score >= thresholds.Good:
+		return MatchQualityGood
+	case score >= thresholds.Fair:
+		return MatchQualityFair
+	default:
+		return MatchQualityPoor
+	}
+}
+
+// 分数统计 - 未被拒绝候选的分数分布，用于调整权重
+type ScoreSummary struct {
+	Ok        bool          // 是否存在未被拒绝的候选，为 false 时其余字段均为零值
+	Count     int           // 参与统计的候选数
+	Min       int16         // 最低分
+	Max       int16         // 最高分
+	Mean      float64       // 平均分
+	Median    float64       // 中位数，偶数个时取中间两个的平均
+	P90       int16         // 90分位（最近秩法）
+	Histogram map[int16]int // 分数分布，分数: 候选数
+}
+
+// 统计分数分布 - 只统计未被拒绝的候选
+func ScoreStats(details []*MatchDetail) ScoreSummary {
+	scores := make([]int16, 0, len(details))
+	total := 0
+	for _, detail := range details {
+		if !detail.Rejected {
+			scores = append(scores, detail.Score)
+			total += int(detail.Score)
+		}
+	}
+	if len(scores) == 0 {
+		return ScoreSummary{}
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i] < scores[j] })
+
+	n := len(scores)
+	median := float64(scores[n/2])
+	if n%2 == 0 {
+		median = (float64(scores[n/2-1]) + float64(scores[n/2])) / 2
+	}
+	rank := int(math.Ceil(0.9*float64(n))) - 1
+	histogram := make(map[int16]int)
+	for _, score := range scores {
+		histogram[score]++
+	}
+
+	return ScoreSummary{
+		Ok:        true,
+		Count:     n,
+		Min:       scores[0],
+		Max:       scores[n-1],
+		Mean:      float64(total) / float64(n),
+		Median:    median,
+		P90:       scores[rank],
+		Histogram: histogram,
+	}
+}
@@ -0,0 +1,195 @@
+package matchroom
+
+import (
+	"sort"
+	"time"
+)
+
+// 两两打分矩阵 - [i][j] 为 entities[i]（用户 userIDs[i]）对候选 entities[j] 的得分
+// 矩阵一般不对称：等待、历史、成功率、活跃度等只看候选一方，黑名单、冷却也按方向检查；
+// 观众差异、地区、语言得分交换双方后不变，每对实体只计算一次并用于两个方向
+// 对角线为实体对自身的得分，未开启 AllowSelfMatch 时为 MinScore
+func ScoreMatrix(entities []*Entity, userIDs []string, config *MatchConfig, now int64) [][]int16 {
+	if len(entities) != len(userIDs) {
+		panic("entities and userIDs length mismatch")
+	}
+
+	n := len(entities)
+	cells := make([]int16, n*n)
+	matrix := make([][]int16, n)
+	for i := range matrix {
+		matrix[i] = cells[i*n : (i+1)*n]
+	}
+
+	segments := make([]uint8, n)
+	for i, entity := range entities {
+		segments[i] = config.segmentOf(entity)
+	}
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			ctx := ScoreContext{
+				UserID:           userIDs[i],
+				Config:           config,
+				CurrentTime:      now,
+				CurrentSegment:   segments[i],
+				CandidateSegment: segments[j],
+			}
+			shared, sharedRejected := runScorers(&MatchDetail{}, entities[i], entities[j], ctx, isSymmetric)
+			matrix[i][j] = scoreDirected(entities[i], entities[j], ctx, shared, sharedRejected)
+			if i != j {
+				ctx.UserID = userIDs[j]
+				ctx.CurrentSegment, ctx.CandidateSegment = segments[j], segments[i]
+				matrix[j][i] = scoreDirected(entities[j], entities[i], ctx, shared, sharedRejected)
+			}
+		}
+	}
+	return matrix
+}
+
+// 单方向得分 - 在对称部分 shared 的基础上执行快速排除检查和非对称打分器
+func scoreDirected(current, candidate *Entity, ctx ScoreContext, shared float64, sharedRejected bool) int16 {
+	if sharedRejected {
+		return MinScore
+	}
+	if code, _ := quickReject(current, candidate, ctx.UserID, ctx.Config, ctx.CurrentTime); code != RejectNone {
+		return MinScore
+	}
+	detail := &MatchDetail{Entity: candidate, CurrentSegment: ctx.CurrentSegment, CandidateSegment: ctx.CandidateSegment}
+	total, rejected := runScorers(detail, current, candidate, ctx, func(scorer Scorer) bool { return !isSymmetric(scorer) })
+	if rejected {
+		return MinScore
+	}
+	detail.finish(shared+total, ctx)
+	return detail.Score
+}
+
+// 配对结果 - Score 为双方互相打分之和
+type MatchPair struct {
+	A, B  *Entity
+	Score int
+}
+
+// 全局配对 - 在 ScoreMatrix 上求总分尽量高的两两配对，每个实体最多出现在一个配对中
+// 只有双方互相打分都不为负（未被拒绝）的两个实体之间才有边，边权为两个方向得分之和
+// 先按边权从高到低贪心配对，再反复尝试交换两对的成员、用空闲实体替换配对成员，直到总分无法提高；
+// 结果是局部最优，对贪心会陷入的“一条高分边挡住两条次高分边”等情况能得到更高的总分
+// 返回的配对按 A 在 entities 中的下标排序，A 的下标总是小于 B
+func PairAll(entities []*Entity, userIDs []string, config *MatchConfig) []MatchPair {
+	matrix := ScoreMatrix(entities, userIDs, config, time.Now().Unix())
+	n := len(entities)
+	weight := func(i, j int) int {
+		if i == j || matrix[i][j] < 0 || matrix[j][i] < 0 {
+			return -1
+		}
+		return int(matrix[i][j]) + int(matrix[j][i])
+	}
+
+	mate := make([]int, n)
+	for i := range mate {
+		mate[i] = -1
+	}
+
+	// 贪心 - 边权相同时按下标顺序，保证结果稳定
+	type edge struct{ i, j, w int }
+	var edges []edge
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if w := weight(i, j); w >= 0 {
+				edges = append(edges, edge{i, j, w})
+			}
+		}
+	}
+	sort.SliceStable(edges, func(a, b int) bool { return edges[a].w > edges[b].w })
+	for _, e := range edges {
+		if mate[e.i] < 0 && mate[e.j] < 0 {
+			mate[e.i], mate[e.j] = e.j, e.i
+		}
+	}
+
+	for improvePairing(mate, weight) {
+	}
+
+	var pairs []MatchPair
+	for i, j := range mate {
+		if j > i {
+			pairs = append(pairs, MatchPair{A: entities[i], B: entities[j], Score: weight(i, j)})
+		}
+	}
+	return pairs
+}
+
+// 配对改进 - 找到一处能提高总分的调整并执行，没有时返回 false；每次调整总分增加或配对数增加，循环必然结束
+func improvePairing(mate []int, weight func(i, j int) int) bool {
+	n := len(mate)
+	link := func(i, j int) { mate[i], mate[j] = j, i }
+
+	for a := 0; a < n; a++ {
+		b := mate[a]
+		if b < a {
+			continue // 未配对，或该配对已从较小下标处理
+		}
+		current := weight(a, b)
+
+		// 交换两对的成员: (a,b)(c,d) -> (a,c)(b,d) 或 (a,d)(b,c)
+		for c := a + 1; c < n; c++ {
+			d := mate[c]
+			if d < c || c == b {
+				continue
+			}
+			before := current + weight(c, d)
+			if w1, w2 := weight(a, c), weight(b, d); w1 >= 0 && w2 >= 0 && w1+w2 > before {
+				link(a, c)
+				link(b, d)
+				return true
+			}
+			if w1, w2 := weight(a, d), weight(b, c); w1 >= 0 && w2 >= 0 && w1+w2 > before {
+				link(a, d)
+				link(b, c)
+				return true
+			}
+		}
+
+		// 用空闲实体替换: (a,b) -> (a,u) 或 (b,u)，可同时让另一方与空闲实体 v 配对
+		for u := 0; u < n; u++ {
+			if mate[u] >= 0 {
+				continue
+			}
+			for _, side := range [2][2]int{{a, b}, {b, a}} {
+				keep, release := side[0], side[1]
+				w1 := weight(keep, u)
+				if w1 < 0 {
+					continue
+				}
+				for v := 0; v < n; v++ {
+					if v == u || mate[v] >= 0 {
+						continue
+					}
+					if w2 := weight(release, v); w2 >= 0 && w1+w2 > current {
+						link(keep, u)
+						link(release, v)
+						return true
+					}
+				}
+				if w1 > current {
+					mate[release] = -1
+					link(keep, u)
+					return true
+				}
+			}
+		}
+	}
+
+	// 两个空闲实体之间有边时直接配对
+	for u := 0; u < n; u++ {
+		if mate[u] >= 0 {
+			continue
+		}
+		for v := u + 1; v < n; v++ {
+			if mate[v] < 0 && weight(u, v) >= 0 {
+				link(u, v)
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,308 @@
+package matchroom
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 候选池 - 按段位分桶存放实体，匹配时跳过必然被段位规则拒绝的候选
+// 同时维护 用户ID -> 拉黑该用户的实体 的反向索引，匹配时直接跳过这些实体，其余候选也不再逐个查询永久黑名单
+// 实体的段位和黑名单在加入时确定，MicCount、Blacklist 或 AllowListMode 变化后需重新 Add 以更新索引
+// 临时屏蔽和白名单模式的实体不进入索引，仍由 quickReject 逐个检查
+type Pool struct {
+	mu        sync.RWMutex
+	byID      map[string]poolEntry
+	segments  map[uint8][]*Entity
+	blockedBy map[string]map[string]struct{} // 用户ID: 拉黑该用户的实体ID集合
+	scores    *poolScoreCache                // ScoreAll 的打分缓存，池内实体增删时失效
+}
+
+// 候选池打分缓存 - 记录完整打分时的参数和每个候选与等待时间无关的部分
+type poolScoreCache struct {
+	current *Entity
+	userID  string
+	config  *MatchConfig
+	entries []poolScore
+}
+
+// 单个候选的缓存得分
+type poolScore struct {
+	detail       *MatchDetail
+	scores       MatchDetail // 只含与等待时间无关的子得分的详情快照，每次重算前以此还原 detail
+	base         float64     // 与等待时间无关的打分器加权和
+	baseCode     RejectCode  // 与等待时间无关的打分器给出的拒绝码，未拒绝时为 RejectNone
+	baseReason   string
+	baseRejected bool
+	hints        scoreHints // 按加入时的反向索引得出的打分提示
+}
+
+// 池内实体及其加入时的段位和黑名单
+type poolEntry struct {
+	entity    *Entity
+	segment   uint8
+	blacklist []string
+}
+
+// 创建候选池
+func NewPool(entities ...*Entity) *Pool {
+	p := &Pool{
+		byID:      make(map[string]poolEntry, len(entities)),
+		segments:  make(map[uint8][]*Entity),
+		blockedBy: make(map[string]map[string]struct{}),
+	}
+	for _, entity := range entities {
+		p.Add(entity)
+	}
+	return p
+}
+
+// 加入实体 - 已存在相同ID时替换
+func (p *Pool) Add(entity *Entity) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.removeLocked(entity.ID)
+	entry := poolEntry{
+		entity:    entity,
+		segment:   entity.Segment(),
+		blacklist: make([]string, 0, len(entity.Blacklist)),
+	}
+	// 白名单模式的实体不进入反向索引，由 quickReject 逐个检查
+	if !entity.AllowListMode {
+		for userID := range entity.Blacklist {
+			entry.blacklist = append(entry.blacklist, userID)
+			if p.blockedBy[userID] == nil {
+				p.blockedBy[userID] = make(map[string]struct{})
+			}
+			p.blockedBy[userID][entity.ID] = struct{}{}
+		}
+	}
+	p.byID[entity.ID] = entry
+	p.segments[entry.segment] = append(p.segments[entry.segment], entity)
+	p.scores = nil
+}
+
+// 移除实体 - 返回是否存在
+func (p *Pool) Remove(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.removeLocked(id)
+}
+
+func (p *Pool) removeLocked(id string) bool {
+	entry, ok := p.byID[id]
+	if !ok {
+		return false
+	}
+	p.scores = nil
+	delete(p.byID, id)
+	p.segments[entry.segment] = removeEntity(p.segments[entry.segment], entry.entity)
+	for _, userID := range entry.blacklist {
+		delete(p.blockedBy[userID], id)
+		if len(p.blockedBy[userID]) == 0 {
+			delete(p.blockedBy, userID)
+		}
+	}
+	return true
+}
+
+// 实体数量
+func (p *Pool) Len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.byID)
+}
+
+// 段位顺序 - 按段位升序遍历分桶，保证相同候选池的打分顺序稳定
+func (p *Pool) segmentOrder() []uint8 {
+	order := make([]uint8, 0, len(p.segments))
+	for segment := range p.segments {
+		order = append(order, segment)
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+	return order
+}
+
+// 匹配 - 同段位候选全部打分；其他段位的候选等待不足60秒时必然被段位规则拒绝，直接跳过
+// 严格段位模式下只打分同段位候选；使用自定义打分器时无法预判等待时间规则，不跳过其他段位
+// 跳过的候选按跳过原因（黑名单或段位）通知 RejectObserver，拒绝码可能与逐个打分时先命中的检查不同
+func (p *Pool) Match(current *Entity, userID string, config *MatchConfig) *Entity {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.matchLocked(current, userID, config)
+}
+
+// 匹配并移除 - 在同一把写锁内完成匹配和移除，刚配对的实体不会再被其他调用方选中
+func (p *Pool) MatchAndRemove(current *Entity, userID string, config *MatchConfig) *Entity {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	matched := p.matchLocked(current, userID, config)
+	if matched != nil {
+		p.removeLocked(matched.ID)
+	}
+	return matched
+}
+
+func (p *Pool) matchLocked(current *Entity, userID string, config *MatchConfig) *Entity {
+	currentTime := time.Now().Unix()
+	currentSeg := config.segmentOf(current)
+	prune := len(config.Scorers) == 0 && config.SegmentMap == nil && config.SegmentDistanceScores == nil
+	strict := config.StrictSegment && config.SegmentMap == nil // 自定义段位时分桶段位与打分段位不一致，不能按桶跳过
+	blockers := p.blockedBy[userID]
+	observed := config.RejectObserver != nil
+
+	var tracker topTracker
+	for _, segment := range p.segmentOrder() {
+		skipSegment := segment != currentSeg && strict
+		if skipSegment && !observed {
+			continue
+		}
+		for _, candidate := range p.segments[segment] {
+			if _, blocked := blockers[candidate.ID]; blocked {
+				notifySkipped(candidate, RejectBlacklisted, config, currentSeg, segment)
+				continue
+			}
+			if skipSegment {
+				notifySkipped(candidate, RejectStrictSegment, config, currentSeg, segment)
+				continue
+			}
+			if prune && segment != currentSeg && candidate.WaitSeconds < 60 {
+				code := RejectSegmentMismatch
+				if segmentDistance(currentSeg, segment) > 1 {
+					code = RejectSegmentGap
+				}
+				notifySkipped(candidate, code, config, currentSeg, segment)
+				continue
+			}
+			// 未被索引跳过的非白名单候选必然不在永久黑名单中，quickReject 只需检查临时屏蔽
+			hints := scoreHints{notBlacklisted: !candidate.AllowListMode}
+			detail := scoreInto(&MatchDetail{}, PerspectiveInitiator, current, candidate, userID, config, currentTime, currentSeg, hints)
+			config.notifyScored(detail)
+			tracker.add(detail)
+		}
+	}
+	return tracker.pick(config, rand.Intn).entity()
+}
+
+// 通知跳过的候选 - 以跳过原因构造拒绝详情通知 RejectObserver，未设置观察者时不构造详情
+func notifySkipped(candidate *Entity, code RejectCode, config *MatchConfig, currentSeg, candidateSeg uint8) {
+	if config.RejectObserver == nil {
+		return
+	}
+	detail := &MatchDetail{Entity: candidate, CurrentSegment: currentSeg, CandidateSegment: candidateSeg}
+	var args []any
+	if code == RejectSegmentGap {
+		args = []any{currentSeg, candidateSeg}
+	}
+	detail.reject(code, ReasonText(code, config.Locale, args...))
+	config.notifyRejected(detail)
+}
+
+// 完整打分 - 对池内所有候选打分并缓存各候选与等待时间无关的得分，供 RescoreWait 增量重算
+// 为了让等待时间增长后可能通过段位规则的候选也能被重算，这里不跳过任何候选
+// 返回的详情归缓存所有，下次 ScoreAll/RescoreWait 时会被原地更新
+func (p *Pool) ScoreAll(current *Entity, userID string, config *MatchConfig, now int64) []*MatchDetail {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cache := &poolScoreCache{
+		current: current,
+		userID:  userID,
+		config:  config,
+		entries: make([]poolScore, 0, len(p.byID)),
+	}
+	ctx := ScoreContext{
+		UserID:         userID,
+		Config:         config,
+		CurrentTime:    now,
+		CurrentSegment: config.segmentOf(current),
+	}
+	blockers := p.blockedBy[userID]
+	for _, segment := range p.segmentOrder() {
+		for _, candidate := range p.segments[segment] {
+			ctx.CandidateSegment = config.segmentOf(candidate)
+			detail := &MatchDetail{Entity: candidate, CurrentSegment: ctx.CurrentSegment, CandidateSegment: ctx.CandidateSegment}
+			base, baseRejected := runScorers(detail, current, candidate, ctx, func(scorer Scorer) bool { return !isWaitDependent(scorer) })
+			entry := poolScore{
+				detail:       detail,
+				base:         base,
+				baseCode:     detail.RejectCode,
+				baseReason:   detail.RejectReason,
+				baseRejected: baseRejected,
+			}
+			if _, blocked := blockers[candidate.ID]; !blocked && !candidate.AllowListMode {
+				entry.hints.notBlacklisted = true
+			}
+			entry.scores = *detail
+			entry.scores.Rejected, entry.scores.RejectCode, entry.scores.RejectReason, entry.scores.Score = false, RejectNone, "", 0
+			cache.entries = append(cache.entries, entry)
+		}
+	}
+	p.scores = cache
+	return p.rescoreLocked(now)
+}
+
+// 等待时间增量重算 - 复用 ScoreAll 缓存的其他得分，只重算等待、段位及冷却、新鲜度等随时间变化的得分后重新求和
+// 快速排除检查（含冷却、临时屏蔽）按 now 重新执行；current 与缓存不一致或池内实体有增删时返回 false，
+// 需重新调用 ScoreAll。候选的上麦、观众等其他属性变化后同样需要重新 ScoreAll
+func (p *Pool) RescoreWait(current *Entity, now int64) ([]*MatchDetail, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.scores == nil || p.scores.current != current {
+		return nil, false
+	}
+	return p.rescoreLocked(now), true
+}
+
+func (p *Pool) rescoreLocked(now int64) []*MatchDetail {
+	cache := p.scores
+	current, config := cache.current, cache.config
+	ctx := ScoreContext{
+		UserID:         cache.userID,
+		Config:         config,
+		CurrentTime:    now,
+		CurrentSegment: config.segmentOf(current),
+	}
+
+	details := make([]*MatchDetail, len(cache.entries))
+	for i := range cache.entries {
+		entry := &cache.entries[i]
+		detail := entry.detail
+		candidate := detail.Entity
+		// 还原快照 - 清除上次重算留下的总分、归一化分、拒绝原因及随时间变化的子得分
+		*detail = entry.scores
+		detail.CurrentSegment = ctx.CurrentSegment
+		ctx.CandidateSegment = detail.CandidateSegment
+		details[i] = detail
+
+		if code, reason := quickRejectWith(current, candidate, cache.userID, config, now, entry.hints); code != RejectNone {
+			detail.reject(code, reason)
+			if !config.explain() {
+				config.notifyRejected(detail)
+				continue
+			}
+		}
+		total, rejected := runScorers(detail, current, candidate, ctx, isWaitDependent)
+		if rejected && !config.explain() {
+			config.notifyRejected(detail)
+			continue
+		}
+		if entry.baseRejected && !detail.Rejected {
+			detail.reject(entry.baseCode, entry.baseReason)
+		}
+		if detail.Rejected {
+			detail.explainRejected(entry.base+total, config)
+			config.notifyRejected(detail)
+			continue
+		}
+		detail.finish(entry.base+total, ctx)
+		if detail.Rejected {
+			config.notifyRejected(detail)
+		}
+	}
+	return details
+}
@@ -0,0 +1,222 @@
+package matchroom
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+)
+
+// 活跃度的协议枚举 - 与 proto3 约定一致，0 保留为未指定
+type ActivityLevelProto int32
+
+const (
+	ActivityLevelProtoUnspecified ActivityLevelProto = 0
+	ActivityLevelProtoLow         ActivityLevelProto = 1
+	ActivityLevelProtoMedium      ActivityLevelProto = 2
+	ActivityLevelProtoHigh        ActivityLevelProto = 3
+)
+
+// 实体的协议消息 - 字段类型与生成的 protobuf 消息一致，RPC 层在此结构与生成代码之间逐字段赋值
+type EntityProto struct {
+	Id                string
+	OwnerId           string
+	Region            string
+	Language          string
+	MicCount          uint32
+	AudienceCount     uint32
+	WaitSeconds       uint32
+	MatchHistory      uint32
+	MatchAttempts     uint32
+	ActivityLevel     ActivityLevelProto
+	LastMatchedUsers  map[string]int64
+	Blacklist         []string
+	TempBlocks        map[string]int64
+	DataFetchedAtUnix int64
+	LastActiveUnix    int64
+	ActivitySinceUnix int64
+	Roles             map[string]uint32
+	Tags              []string
+	PreferredHours    uint32
+	BoostAmount       int32
+	BoostUntilUnix    int64
+	AllowListMode     bool
+}
+
+// 上麦人数 - 与生成代码一致，nil 时返回零值
+func (p *EntityProto) GetMicCount() uint32 {
+	if p == nil {
+		return 0
+	}
+	return p.MicCount
+}
+
+// 观众人数
+func (p *EntityProto) GetAudienceCount() uint32 {
+	if p == nil {
+		return 0
+	}
+	return p.AudienceCount
+}
+
+// 等待时间（秒）
+func (p *EntityProto) GetWaitSeconds() uint32 {
+	if p == nil {
+		return 0
+	}
+	return p.WaitSeconds
+}
+
+// 历史成功匹配次数
+func (p *EntityProto) GetMatchHistory() uint32 {
+	if p == nil {
+		return 0
+	}
+	return p.MatchHistory
+}
+
+// 历史匹配尝试次数
+func (p *EntityProto) GetMatchAttempts() uint32 {
+	if p == nil {
+		return 0
+	}
+	return p.MatchAttempts
+}
+
+// 活跃度
+func (p *EntityProto) GetActivityLevel() ActivityLevelProto {
+	if p == nil {
+		return ActivityLevelProtoUnspecified
+	}
+	return p.ActivityLevel
+}
+
+// 转换为协议枚举
+func (a ActivityLevel) ToProto() ActivityLevelProto {
+	switch a {
+	case ActivityHigh:
+		return ActivityLevelProtoHigh
+	case ActivityMedium:
+		return ActivityLevelProtoMedium
+	default:
+		return ActivityLevelProtoLow
+	}
+}
+
+// 从协议枚举转换 - 未指定时视为低活跃度
+func ActivityLevelFromProto(level ActivityLevelProto) (ActivityLevel, error) {
+	switch level {
+	case ActivityLevelProtoHigh:
+		return ActivityHigh, nil
+	case ActivityLevelProtoMedium:
+		return ActivityMedium, nil
+	case ActivityLevelProtoLow, ActivityLevelProtoUnspecified:
+		return ActivityLow, nil
+	default:
+		return ActivityLow, fmt.Errorf("unknown activity level enum %d", level)
+	}
+}
+
+// 转换为协议消息 - 黑名单输出为排序后的数组，白名单模式下同一数组按白名单解释
+func (e *Entity) ToProto() *EntityProto {
+	blacklist := make([]string, 0, len(e.Blacklist))
+	for userID := range e.Blacklist {
+		blacklist = append(blacklist, userID)
+	}
+	sort.Strings(blacklist)
+	var roles map[string]uint32
+	if len(e.Roles) > 0 {
+		roles = make(map[string]uint32, len(e.Roles))
+		for role, count := range e.Roles {
+			roles[role] = uint32(count)
+		}
+	}
+
+	return &EntityProto{
+		Id:                e.ID,
+		OwnerId:           e.OwnerID,
+		Region:            e.Region,
+		Language:          e.Language,
+		MicCount:          uint32(e.MicCount),
+		AudienceCount:     uint32(e.AudienceCount),
+		WaitSeconds:       uint32(e.WaitSeconds),
+		MatchHistory:      uint32(e.MatchHistory),
+		MatchAttempts:     uint32(e.MatchAttempts),
+		ActivityLevel:     e.ActivityLevel.ToProto(),
+		LastMatchedUsers:  maps.Clone(e.LastMatchedUsers),
+		Blacklist:         blacklist,
+		TempBlocks:        maps.Clone(e.TempBlocks),
+		DataFetchedAtUnix: e.DataFetchedAtUnix,
+		LastActiveUnix:    e.LastActiveUnix,
+		ActivitySinceUnix: e.ActivitySinceUnix,
+		Roles:             roles,
+		Tags:              slices.Clone(e.Tags),
+		PreferredHours:    e.PreferredHours,
+		BoostAmount:       int32(e.BoostAmount),
+		BoostUntilUnix:    e.BoostUntilUnix,
+		AllowListMode:     e.AllowListMode,
+	}
+}
+
+// 从协议消息转换 - 计数、角色人数超出 uint16 范围，推广加分超出 int16 范围或枚举未知时返回错误
+func EntityFromProto(p *EntityProto) (*Entity, error) {
+	if p == nil {
+		return nil, fmt.Errorf("nil entity message")
+	}
+	counts := []struct {
+		name  string
+		value uint32
+	}{
+		{"mic_count", p.GetMicCount()},
+		{"audience_count", p.GetAudienceCount()},
+		{"wait_seconds", p.GetWaitSeconds()},
+		{"match_history", p.GetMatchHistory()},
+		{"match_attempts", p.GetMatchAttempts()},
+	}
+	for _, count := range counts {
+		if count.value > math.MaxUint16 {
+			return nil, fmt.Errorf("%s %d out of range", count.name, count.value)
+		}
+	}
+	for role, count := range p.Roles {
+		if count > math.MaxUint16 {
+			return nil, fmt.Errorf("role %s count %d out of range", role, count)
+		}
+	}
+	if p.BoostAmount < math.MinInt16 || p.BoostAmount > math.MaxInt16 {
+		return nil, fmt.Errorf("boost_amount %d out of range", p.BoostAmount)
+	}
+	level, err := ActivityLevelFromProto(p.GetActivityLevel())
+	if err != nil {
+		return nil, err
+	}
+
+	e := NewEntity(p.Id,
+		WithOwnerID(p.OwnerId),
+		WithRegion(p.Region),
+		WithLanguage(p.Language),
+		WithMicCount(uint16(p.MicCount)),
+		WithAudienceCount(uint16(p.AudienceCount)),
+		WithWaitSeconds(uint16(p.WaitSeconds)),
+		WithMatchHistory(uint16(p.MatchHistory)),
+		WithMatchAttempts(uint16(p.MatchAttempts)),
+		WithActivity(level),
+		WithBlacklist(p.Blacklist...),
+		WithDataFetchedAt(p.DataFetchedAtUnix),
+		WithLastActive(p.LastActiveUnix),
+		WithActivitySince(level, p.ActivitySinceUnix),
+		WithTags(p.Tags...),
+		WithBoost(int16(p.BoostAmount), p.BoostUntilUnix),
+	)
+	for role, count := range p.Roles {
+		WithRole(role, uint16(count))(e)
+	}
+	e.PreferredHours = p.PreferredHours
+	e.AllowListMode = p.AllowListMode
+	for userID, at := range p.LastMatchedUsers {
+		e.LastMatchedUsers[userID] = at
+	}
+	e.TempBlocks = maps.Clone(p.TempBlocks)
+	return e, nil
+}
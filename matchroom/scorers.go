@@ -0,0 +1,712 @@
+package matchroom
+
+import (
+	"math"
+	"slices"
+)
+
+// 等待时间得分 - 按 WaitCurve 选择曲线，所有曲线在 MaxWaitTime 处得分相同
+func scoreWaitTime(seconds uint16, config *MatchConfig) int16 {
+	if config.WaitCurve == WaitCurveLegacy || config.MaxWaitTime <= config.MinWaitTime {
+		return scoreWaitLegacy(seconds, config)
+	}
+
+	// progress 为 (MinWaitTime, MaxWaitTime] 区间内的进度，取值0-1
+	span := float64(config.MaxWaitTime - config.MinWaitTime)
+	elapsed := min(max(float64(int(seconds)-config.MinWaitTime), 0), span)
+	var progress float64
+	switch config.WaitCurve {
+	case WaitCurveStep:
+		if steps := math.Floor(span / waitCurveStepSeconds); steps > 0 {
+			progress = math.Floor(elapsed/waitCurveStepSeconds) / steps
+		} else if elapsed >= span {
+			progress = 1
+		}
+	case WaitCurveLinear:
+		progress = elapsed / span
+	case WaitCurveLogarithmic:
+		progress = math.Log1p(elapsed) / math.Log1p(span)
+	}
+	maxScore := scoreWaitLegacy(uint16(min(config.MaxWaitTime, math.MaxUint16)), config)
+	return int16(math.Round(min(progress, 1) * float64(maxScore)))
+}
+
+// 等待时间曲线枚举 - 非 Legacy 曲线要求 MaxWaitTime 大于 MinWaitTime
+type WaitCurve uint8
+
+const (
+	WaitCurveLegacy      WaitCurve = iota // 原有分段线性曲线，60秒前每10秒1分，之后每10秒2分（默认）
+	WaitCurveStep                         // 阶梯曲线，每等待满 waitCurveStepSeconds 秒提升一档
+	WaitCurveLinear                       // 线性曲线，得分与等待时间成正比
+	WaitCurveLogarithmic                  // 对数曲线，前期增长快，后期趋缓
+)
+
+// 阶梯曲线每档的秒数
+const waitCurveStepSeconds = 60
+
+// 原有等待时间得分 - 优化计算
+// 超过 MaxWaitTime 的等待不再加分，默认配置下最高为 4 + (300-60)/10*2 = 52 分
+func scoreWaitLegacy(seconds uint16, config *MatchConfig) int16 {
+	if config.MaxWaitTime > 0 && int(seconds) > config.MaxWaitTime {
+		seconds = uint16(config.MaxWaitTime)
+	}
+	if seconds <= uint16(config.MinWaitTime) {
+		return 0
+	}
+	if seconds <= 60 {
+		return int16((seconds - uint16(config.MinWaitTime)) / 10)
+	}
+	return 4 + int16((seconds-60)/10*2)
+}
+
+// 段位距离得分项 - 候选等待时间不少于 MinWait 时得 Score 分，否则拒绝
+type SegmentDistanceScore struct {
+	Score   int16  `json:"score"`
+	MinWait uint16 `json:"min_wait"`
+}
+
+// 上麦人数段一致性得分 - 配置了段位距离得分表时按距离查表，超出表长的距离一律拒绝
+func scoreMicSegment(currentSeg, candidateSeg uint8, waitTime uint16, table []SegmentDistanceScore) int16 {
+	if table != nil {
+		diff := segmentDistance(currentSeg, candidateSeg)
+		if int(diff) < len(table) && waitTime >= table[diff].MinWait {
+			return table[diff].Score
+		}
+		return MinScore
+	}
+	if currentSeg == candidateSeg {
+		return 10
+	}
+
+	diff := segmentDistance(currentSeg, candidateSeg)
+	if diff == 1 && waitTime >= 60 {
+		return 3
+	}
+	if waitTime >= 60 {
+		return 0
+	}
+	return MinScore // 不允许匹配
+}
+
+// 观众人数差得分 - 使用查表优化
+// 默认分值表按观众差下标取分，超出表长得0分
+var audienceDiffScores = []int16{5, 4, 3, 2, 1, 0}
+
+func scoreAudienceDiff(diff int, scores []int16) int16 {
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff < len(scores) {
+		return scores[diff]
+	}
+	return 0
+}
+
+// 观众人数比例分档 - 比例（较小/较大）不低于 MinRatio 时得 Score 分，从高到低匹配
+// >=0.95: 5分, >=0.9: 4分, >=0.8: 3分, >=0.7: 2分, >=0.5: 1分, 其余: 0分
+var audienceRatioBands = [...]struct {
+	MinRatio float64
+	Score    int16
+}{
+	{0.95, 5},
+	{0.9, 4},
+	{0.8, 3},
+	{0.7, 2},
+	{0.5, 1},
+}
+
+// 观众人数比例得分 - 10对20与200对210不再按相同的绝对差处理
+func scoreAudienceRatio(a, b uint16) int16 {
+	if a > b {
+		a, b = b, a
+	}
+	if b == 0 {
+		return audienceRatioBands[0].Score // 两边都没有观众，视为规模一致
+	}
+
+	ratio := float64(a) / float64(b)
+	for _, band := range audienceRatioBands {
+		if ratio >= band.MinRatio {
+			return band.Score
+		}
+	}
+	return 0
+}
+
+// 历史成功匹配得分 - 优化条件判断
+func scoreMatchHistory(history uint16) int16 {
+	if history >= 10 {
+		return 4
+	}
+	if history >= 5 {
+		return 2
+	}
+	return 0
+}
+
+// 历史成功率得分 - 按 成功次数/尝试次数 线性给分，没有尝试记录的新房间不计分
+func scoreSuccessRate(successes, attempts uint16, maxScore int16) int16 {
+	if attempts == 0 || maxScore <= 0 {
+		return 0
+	}
+	ratio := float64(successes) / float64(attempts)
+	if ratio > 1 {
+		ratio = 1
+	}
+	return int16(math.Round(ratio * float64(maxScore)))
+}
+
+// 角色失衡度 - (最多角色人数-最少角色人数)/总人数，角色取两个分布的并集，缺失的角色按0人计
+func roleImbalance(roles map[string]uint16, union map[string]struct{}) float64 {
+	var total, maxCount, minCount int
+	minCount = math.MaxInt
+	for role := range union {
+		count := int(roles[role])
+		total += count
+		maxCount = max(maxCount, count)
+		minCount = min(minCount, count)
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(maxCount-minCount) / float64(total)
+}
+
+// 角色平衡得分 - 按合并后失衡度相对当前失衡度的降低比例给分，不改善或加重失衡得0分
+// 例如当前3男1女、候选1男3女时合并后完全平衡，得满分；候选同为3男1女时不改善，得0分
+func scoreRoleBalance(current, candidate map[string]uint16, maxScore int16) int16 {
+	if maxScore <= 0 || len(current) == 0 || len(candidate) == 0 {
+		return 0
+	}
+	union := make(map[string]struct{}, len(current)+len(candidate))
+	combined := make(map[string]uint16, len(current)+len(candidate))
+	for role, count := range current {
+		union[role] = struct{}{}
+		combined[role] += count
+	}
+	for role, count := range candidate {
+		union[role] = struct{}{}
+		combined[role] += count
+	}
+
+	before := roleImbalance(current, union)
+	if before == 0 {
+		return 0
+	}
+	improvement := (before - roleImbalance(combined, union)) / before
+	if improvement <= 0 {
+		return 0
+	}
+	return int16(math.Round(improvement * float64(maxScore)))
+}
+
+// 标签重合得分 - Jaccard 相似度乘以上限，重复标签只计一次；任一方没有标签时得0分
+func scoreTagOverlap(a, b []string, maxScore int16) int16 {
+	if maxScore <= 0 || len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	const inA, inB = 1, 2
+	set := make(map[string]uint8, len(a)+len(b)) // 标签: 出现在哪一方
+	for _, tag := range a {
+		set[tag] |= inA
+	}
+	for _, tag := range b {
+		set[tag] |= inB
+	}
+	shared := 0
+	for _, from := range set {
+		if from == inA|inB {
+			shared++
+		}
+	}
+	union := len(set)
+	return int16(math.Round(float64(shared) / float64(union) * float64(maxScore)))
+}
+
+// 新鲜度得分 - 按距上次活跃的时间指数衰减；活跃时间未知时得0分，晚于当前时间时按刚活跃处理
+func scoreFreshness(lastActive, now int64, maxScore int16, halfLife int64) int16 {
+	if maxScore <= 0 || halfLife <= 0 || lastActive == 0 {
+		return 0
+	}
+	age := max(now-lastActive, 0)
+	return int16(math.Round(float64(maxScore) * math.Exp2(-float64(age)/float64(halfLife))))
+}
+
+// 时段得分 - now 按 offset 换算为当地小时，落在偏好时段位图内时得 bonus 分
+func scoreTimeOfDay(preferred uint32, now int64, offset int, bonus int16) int16 {
+	if bonus <= 0 || preferred == 0 {
+		return 0
+	}
+	const day = 24 * 3600
+	hour := ((now+int64(offset))%day + day) % day / 3600
+	if preferred&(1<<hour) == 0 {
+		return 0
+	}
+	return bonus
+}
+
+// 补位得分 - 观众越少得分越高，空房间得 maxScore，达到目标时为0；超过目标时得0分或按比例扣分
+func scoreFill(audience, target uint16, maxScore int16, overPenalty bool) int16 {
+	if target == 0 || maxScore <= 0 {
+		return 0
+	}
+	fill := 1 - float64(audience)/float64(target)
+	if fill < 0 {
+		if !overPenalty {
+			return 0
+		}
+		fill = max(fill, -1)
+	}
+	return int16(math.Round(fill * float64(maxScore)))
+}
+
+// 推广得分 - 未到期时为 amount，到期后立即归零；amount 不为正时不加分
+func scoreBoost(amount int16, until, now int64) int16 {
+	if amount <= 0 || now >= until {
+		return 0
+	}
+	return amount
+}
+
+// 活跃度得分 - 使用数组查表
+var activityScores = [...]int16{0, 2, 3} // low, medium, high
+
+func scoreActivityLevel(level ActivityLevel) int16 {
+	if level < ActivityLevel(len(activityScores)) {
+		return activityScores[level]
+	}
+	return 0
+}
+
+// 活跃度得分 - 等级得分加上近期升级加成
+func scoreActivity(level ActivityLevel, since, now int64, config *MatchConfig) int16 {
+	total := int32(scoreActivityLevel(level)) + int32(scoreActivityMomentum(level, since, now, config.ActivityMomentumBonus, config.ActivityMomentumWindow))
+	return int16(min(total, math.MaxInt16))
+}
+
+// 活跃度升级加成 - 刚进入中高活跃度的房间得满额加成，在 window 秒内线性衰减到0
+// 进入时间未知或低活跃度时不加分，进入时间晚于当前时间时按刚进入处理
+func scoreActivityMomentum(level ActivityLevel, since, now int64, bonus int16, window int64) int16 {
+	if bonus <= 0 || window <= 0 || since == 0 || level == ActivityLow {
+		return 0
+	}
+	age := max(now-since, 0)
+	if age >= window {
+		return 0
+	}
+	return int16(int64(bonus) * (window - age) / window)
+}
+
+// 打分上下文 - 每次打分时传给打分器的共享信息
+type ScoreContext struct {
+	UserID           string       // 当前用户ID
+	Config           *MatchConfig // 匹配配置
+	CurrentTime      int64        // 当前时间戳
+	CurrentSegment   uint8        // 当前实体段位
+	CandidateSegment uint8        // 候选实体段位
+	Perspective      Perspective  // 打分视角，决定使用哪一组权重
+	PreviousID       string       // 同一用户上一次选中的候选ID，仅 MatchSticky 设置，供 StickinessScorer 加分
+}
+
+// 打分视角枚举 - 匹配时 current 总是主动发起方；以被匹配方视角打分需通过 ScoreAs 显式指定
+type Perspective uint8
+
+const (
+	PerspectiveInitiator Perspective = iota // current 为主动发起匹配的一方（默认），使用 InitiatorWeights
+	PerspectiveTarget                       // current 为被匹配的一方，使用 Weights
+)
+
+// 打分器接口 - 可插拔的打分规则，返回得分；rejected 为 true 时直接排除候选
+type Scorer interface {
+	Score(current, candidate *Entity, ctx ScoreContext) (score int16, rejected bool, reason string)
+}
+
+// 内置打分器 - 额外负责写回 MatchDetail 对应字段并提供权重
+type builtinScorer interface {
+	Scorer
+	record(detail *MatchDetail, score int16)
+	weight(w *ScoreWeights) float64
+}
+
+// 满分提供者 - 内置打分器在给定配置下可能得到的最高分，用于分数归一化
+type maxScorer interface {
+	maxScore(config *MatchConfig) int16
+}
+
+// 等待相关打分器 - 得分随候选等待时间或当前时间变化，Pool.RescoreWait 只重算这类打分器
+type waitDependent interface {
+	waitDependent()
+}
+
+// 是否为等待相关打分器
+func isWaitDependent(scorer Scorer) bool {
+	_, ok := scorer.(waitDependent)
+	return ok
+}
+
+// 对称打分器 - 交换 current 和 candidate 后得分不变，ScoreMatrix 对每对实体只计算一次
+type symmetric interface {
+	symmetric()
+}
+
+// 是否为对称打分器
+func isSymmetric(scorer Scorer) bool {
+	_, ok := scorer.(symmetric)
+	return ok
+}
+
+// 拒绝码提供者 - 内置打分器拒绝时返回对应的拒绝码，其余打分器使用 RejectScorer
+type rejectCoder interface {
+	rejectCode() RejectCode
+}
+
+// 等待时间打分器
+type WaitScorer struct{}
+
+func (WaitScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	return scoreWaitTime(candidate.WaitSeconds, ctx.Config), false, ""
+}
+
+func (WaitScorer) record(detail *MatchDetail, score int16) { detail.WaitScore = score }
+func (WaitScorer) weight(w *ScoreWeights) float64          { return w.wait() }
+func (WaitScorer) waitDependent()                          {}
+func (WaitScorer) maxScore(config *MatchConfig) int16 {
+	if config.MaxWaitTime > 0 && config.MaxWaitTime < math.MaxUint16 {
+		return scoreWaitTime(uint16(config.MaxWaitTime), config)
+	}
+	return scoreWaitTime(math.MaxUint16, config)
+}
+
+// 段位一致性打分器 - 段位不允许匹配时排除
+type SegmentScorer struct{}
+
+func (SegmentScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	score := scoreMicSegment(ctx.CurrentSegment, ctx.CandidateSegment, candidate.WaitSeconds, ctx.Config.SegmentDistanceScores)
+	if score < 0 {
+		return score, true, ReasonText(RejectSegmentMismatch, ctx.Config.Locale)
+	}
+	return score, false, ""
+}
+
+func (SegmentScorer) record(detail *MatchDetail, score int16) { detail.SegmentScore = score }
+func (SegmentScorer) weight(w *ScoreWeights) float64          { return w.segment() }
+func (SegmentScorer) waitDependent()                          {}
+func (SegmentScorer) maxScore(config *MatchConfig) int16 {
+	if config.SegmentDistanceScores == nil {
+		return 10
+	}
+	best := int16(0)
+	for _, entry := range config.SegmentDistanceScores {
+		best = max(best, entry.Score)
+	}
+	return best
+}
+func (SegmentScorer) rejectCode() RejectCode { return RejectSegmentMismatch }
+
+// 观众差异打分器
+type AudienceScorer struct{}
+
+func (AudienceScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	config := ctx.Config
+	if config.AudienceMode == AudienceRatio {
+		return scoreAudienceRatio(current.AudienceCount, candidate.AudienceCount), false, ""
+	}
+	diff := config.audienceBucket(current.AudienceCount) - config.audienceBucket(candidate.AudienceCount)
+	return scoreAudienceDiff(diff, config.audienceDiffScores()), false, ""
+}
+
+func (AudienceScorer) record(detail *MatchDetail, score int16) { detail.AudienceScore = score }
+func (AudienceScorer) weight(w *ScoreWeights) float64          { return w.audience() }
+func (AudienceScorer) symmetric()                              {}
+func (AudienceScorer) maxScore(config *MatchConfig) int16 {
+	if config.AudienceMode == AudienceRatio {
+		return audienceRatioBands[0].Score
+	}
+	return slices.Max(config.audienceDiffScores())
+}
+
+// 历史匹配打分器
+type HistoryScorer struct{}
+
+func (HistoryScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	return scoreMatchHistory(candidate.MatchHistory), false, ""
+}
+
+func (HistoryScorer) record(detail *MatchDetail, score int16) { detail.HistoryScore = score }
+func (HistoryScorer) weight(w *ScoreWeights) float64          { return w.history() }
+func (HistoryScorer) maxScore(config *MatchConfig) int16      { return scoreMatchHistory(math.MaxUint16) }
+
+// 历史成功率打分器 - 与历史次数得分叠加，分值上限即为权重
+type SuccessRateScorer struct{}
+
+func (SuccessRateScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	return scoreSuccessRate(candidate.MatchHistory, candidate.MatchAttempts, ctx.Config.SuccessRateMaxScore), false, ""
+}
+
+func (SuccessRateScorer) record(detail *MatchDetail, score int16) { detail.SuccessRateScore = score }
+func (SuccessRateScorer) weight(w *ScoreWeights) float64          { return 1 }
+func (SuccessRateScorer) maxScore(config *MatchConfig) int16      { return config.SuccessRateMaxScore }
+
+// 活跃度打分器
+type ActivityScorer struct{}
+
+func (ActivityScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	if ctx.Config.ActivityMomentumBonus > 0 {
+		return scoreActivity(candidate.ActivityLevel, candidate.ActivitySinceUnix, ctx.CurrentTime, ctx.Config), false, ""
+	}
+	return scoreActivityLevel(candidate.ActivityLevel), false, ""
+}
+
+func (ActivityScorer) record(detail *MatchDetail, score int16) {
+	detail.ActivityScore = score
+	detail.ActivityMomentum = score - scoreActivityLevel(detail.Entity.ActivityLevel)
+}
+
+func (ActivityScorer) weight(w *ScoreWeights) float64 { return w.activity() }
+func (ActivityScorer) maxScore(config *MatchConfig) int16 {
+	return int16(min(int32(slices.Max(activityScores[:]))+int32(max(config.ActivityMomentumBonus, 0)), math.MaxInt16))
+}
+func (ActivityScorer) waitDependent() {}
+
+// 地区亲和打分器 - 同地区加分，跨地区扣分或拒绝；任一方地区未知时不计分
+// 加分和扣分本身即为可配置的分值，不再额外乘以权重
+type RegionScorer struct{}
+
+func (RegionScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	if current.Region == "" || candidate.Region == "" {
+		return 0, false, ""
+	}
+	if current.Region == candidate.Region {
+		return ctx.Config.RegionBonus, false, ""
+	}
+	if ctx.Config.RejectCrossRegion {
+		return MinScore, true, ReasonText(RejectCrossRegion, ctx.Config.Locale, current.Region, candidate.Region)
+	}
+	return -ctx.Config.RegionPenalty, false, ""
+}
+
+func (RegionScorer) record(detail *MatchDetail, score int16) { detail.RegionScore = score }
+func (RegionScorer) weight(w *ScoreWeights) float64          { return 1 }
+func (RegionScorer) symmetric()                              {}
+func (RegionScorer) maxScore(config *MatchConfig) int16      { return config.RegionBonus }
+func (RegionScorer) rejectCode() RejectCode                  { return RejectCrossRegion }
+
+// 语言偏好得分 - 相同语言得满分，兼容语言得部分分
+const (
+	languageExactScore      int16 = 5
+	languageCompatibleScore int16 = 2
+)
+
+// 语言偏好打分器 - 任一方语言未知时不计分
+type LanguageScorer struct{}
+
+func (LanguageScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	if current.Language == "" || candidate.Language == "" {
+		return 0, false, ""
+	}
+	if current.Language == candidate.Language {
+		return languageExactScore, false, ""
+	}
+	if ctx.Config.languagesCompatible(current.Language, candidate.Language) {
+		return languageCompatibleScore, false, ""
+	}
+	return 0, false, ""
+}
+
+func (LanguageScorer) record(detail *MatchDetail, score int16) { detail.LanguageScore = score }
+func (LanguageScorer) weight(w *ScoreWeights) float64          { return w.language() }
+func (LanguageScorer) symmetric()                              {}
+func (LanguageScorer) maxScore(config *MatchConfig) int16      { return languageExactScore }
+
+// 语言是否兼容 - 两个方向任一配置即视为兼容
+func (c *MatchConfig) languagesCompatible(a, b string) bool {
+	for _, lang := range c.CompatibleLanguages[a] {
+		if lang == b {
+			return true
+		}
+	}
+	for _, lang := range c.CompatibleLanguages[b] {
+		if lang == a {
+			return true
+		}
+	}
+	return false
+}
+
+// 软冷却打分器 - 冷却期内按剩余冷却时间比例扣分，仅在 SoftCooldown 开启时生效
+type CooldownScorer struct{}
+
+func (CooldownScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	if !ctx.Config.SoftCooldown {
+		return 0, false, ""
+	}
+	lastTime, ok := candidate.LastMatchedUsers[ctx.UserID]
+	if !ok {
+		return 0, false, ""
+	}
+
+	cooldown := ctx.Config.cooldownFor(candidate.ActivityLevel)
+	remaining := cooldown - (ctx.CurrentTime - lastTime)
+	if cooldown <= 0 || remaining <= 0 {
+		return 0, false, ""
+	}
+	if remaining > cooldown {
+		remaining = cooldown // 匹配时间晚于当前时间，按刚匹配过处理
+	}
+	return -int16(int64(ctx.Config.CooldownPenalty) * remaining / cooldown), false, ""
+}
+
+func (CooldownScorer) record(detail *MatchDetail, score int16) { detail.CooldownScore = score }
+func (CooldownScorer) weight(w *ScoreWeights) float64          { return 1 }
+func (CooldownScorer) maxScore(config *MatchConfig) int16      { return 0 }
+func (CooldownScorer) waitDependent()                          {}
+
+// 互相匹配打分器 - 双方都在冷却期外、有效窗口内匹配过对方时加分
+// 冷却期内的记录仍由 quickReject 拒绝（软冷却模式下扣分），不会得到加分
+type MutualHistoryScorer struct{}
+
+func (MutualHistoryScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	config := ctx.Config
+	if config.MutualHistoryBonus == 0 {
+		return 0, false, ""
+	}
+
+	theirs, ok := candidate.LastMatchedUsers[ctx.UserID]
+	if !ok || !config.inGoodHistory(ctx.CurrentTime-theirs, candidate.ActivityLevel) {
+		return 0, false, ""
+	}
+	ours, ok := current.LastMatchedUsers[candidate.Owner()]
+	if !ok || !config.inGoodHistory(ctx.CurrentTime-ours, current.ActivityLevel) {
+		return 0, false, ""
+	}
+	return config.MutualHistoryBonus, false, ""
+}
+
+func (MutualHistoryScorer) record(detail *MatchDetail, score int16) {
+	detail.MutualHistoryScore = score
+}
+func (MutualHistoryScorer) weight(w *ScoreWeights) float64     { return 1 }
+func (MutualHistoryScorer) maxScore(config *MatchConfig) int16 { return config.MutualHistoryBonus }
+func (MutualHistoryScorer) waitDependent()                     {}
+
+// 是否为有效的历史匹配 - 已过冷却期且未超出有效窗口
+func (c *MatchConfig) inGoodHistory(elapsed int64, level ActivityLevel) bool {
+	if elapsed < c.cooldownFor(level) {
+		return false
+	}
+	return c.MutualHistoryWindow == 0 || elapsed <= c.MutualHistoryWindow
+}
+
+// 角色平衡打分器 - 候选角色分布与当前实体互补时加分，分值上限即为权重
+type BalanceScorer struct{}
+
+func (BalanceScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	return scoreRoleBalance(current.Roles, candidate.Roles, ctx.Config.BalanceMaxScore), false, ""
+}
+
+func (BalanceScorer) record(detail *MatchDetail, score int16) { detail.BalanceScore = score }
+func (BalanceScorer) weight(w *ScoreWeights) float64          { return 1 }
+func (BalanceScorer) maxScore(config *MatchConfig) int16      { return config.BalanceMaxScore }
+
+// 标签重合打分器 - 兴趣标签越接近得分越高，分值上限即为权重
+type TagScorer struct{}
+
+func (TagScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	return scoreTagOverlap(current.Tags, candidate.Tags, ctx.Config.TagMaxScore), false, ""
+}
+
+func (TagScorer) record(detail *MatchDetail, score int16) { detail.TagScore = score }
+func (TagScorer) weight(w *ScoreWeights) float64          { return 1 }
+func (TagScorer) maxScore(config *MatchConfig) int16      { return config.TagMaxScore }
+func (TagScorer) symmetric()                              {}
+
+// 新鲜度打分器 - 同等活跃度下，久未活跃的房间得分更低
+type FreshnessScorer struct{}
+
+func (FreshnessScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	config := ctx.Config
+	return scoreFreshness(candidate.LastActiveUnix, ctx.CurrentTime, config.FreshnessMaxScore, config.FreshnessHalfLife), false, ""
+}
+
+func (FreshnessScorer) record(detail *MatchDetail, score int16) { detail.FreshnessScore = score }
+func (FreshnessScorer) weight(w *ScoreWeights) float64          { return 1 }
+func (FreshnessScorer) maxScore(config *MatchConfig) int16      { return config.FreshnessMaxScore }
+func (FreshnessScorer) waitDependent()                          {}
+
+// 时段打分器 - 当前时间落在候选偏好时段内时加分，分值即为权重
+type TimeOfDayScorer struct{}
+
+func (TimeOfDayScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	config := ctx.Config
+	return scoreTimeOfDay(candidate.PreferredHours, ctx.CurrentTime, config.TimeOfDayOffset, config.TimeOfDayBonus), false, ""
+}
+
+func (TimeOfDayScorer) record(detail *MatchDetail, score int16) { detail.TimeOfDayScore = score }
+func (TimeOfDayScorer) weight(w *ScoreWeights) float64          { return 1 }
+func (TimeOfDayScorer) maxScore(config *MatchConfig) int16      { return config.TimeOfDayBonus }
+func (TimeOfDayScorer) waitDependent()                          {}
+
+// 补位打分器 - 优先把用户分配到低于目标观众人数的房间，分值上限即为权重
+type FillScorer struct{}
+
+func (FillScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	config := ctx.Config
+	return scoreFill(candidate.AudienceCount, config.TargetAudience, config.FillMaxScore, config.FillOverPenalty), false, ""
+}
+
+func (FillScorer) record(detail *MatchDetail, score int16) { detail.FillScore = score }
+func (FillScorer) weight(w *ScoreWeights) float64          { return 1 }
+func (FillScorer) maxScore(config *MatchConfig) int16 {
+	if config.TargetAudience == 0 {
+		return 0
+	}
+	return config.FillMaxScore
+}
+
+// 推广打分器 - 运营推广的房间在有效期内固定加分
+// 加分因实体而异，不计入理论满分，归一化后推广房间的分数可能被截断在100
+type BoostScorer struct{}
+
+func (BoostScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	return scoreBoost(candidate.BoostAmount, candidate.BoostUntilUnix, ctx.CurrentTime), false, ""
+}
+
+func (BoostScorer) record(detail *MatchDetail, score int16) { detail.BoostScore = score }
+func (BoostScorer) weight(w *ScoreWeights) float64          { return 1 }
+func (BoostScorer) waitDependent()                          {}
+
+// 粘性打分器 - MatchSticky 中上一次选中的候选加分，避免每次匹配因并列随机而切换推荐
+// 只有一个候选能得到加分，不计入理论满分
+type StickinessScorer struct{}
+
+func (StickinessScorer) Score(current, candidate *Entity, ctx ScoreContext) (int16, bool, string) {
+	config := ctx.Config
+	if config.StickinessBonus <= 0 || ctx.PreviousID == "" || candidate.ID != ctx.PreviousID {
+		return 0, false, ""
+	}
+	return config.StickinessBonus, false, ""
+}
+
+func (StickinessScorer) record(detail *MatchDetail, score int16) { detail.StickinessScore = score }
+func (StickinessScorer) weight(w *ScoreWeights) float64          { return 1 }
+
+// 默认打分器 - 与原有打分规则一致，自定义打分器的权重固定为1
+var DefaultScorers = []Scorer{
+	WaitScorer{},
+	SegmentScorer{},
+	AudienceScorer{},
+	HistoryScorer{},
+	SuccessRateScorer{},
+	ActivityScorer{},
+	RegionScorer{},
+	LanguageScorer{},
+	CooldownScorer{},
+	MutualHistoryScorer{},
+	BalanceScorer{},
+	TagScorer{},
+	FreshnessScorer{},
+	TimeOfDayScorer{},
+	BoostScorer{},
+	StickinessScorer{},
+	FillScorer{},
+}
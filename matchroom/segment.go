@@ -0,0 +1,81 @@
+package matchroom
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// 预计算的分段映射 - 避免重复计算
+var micSegmentMap = [...]uint8{0, 1, 1, 1, 2, 2, 2, 3, 3, 3, 3, 3, 3, 3, 3, 3}
+
+// 段位计算回调 - 供基准测试统计 getMicSegment 的调用次数，为 nil 时不统计
+var onMicSegment func()
+
+// 分段判定 - 优化为查表
+func getMicSegment(micCount uint16) uint8 {
+	if onMicSegment != nil {
+		onMicSegment()
+	}
+	if micCount == 0 {
+		return 0
+	}
+	if micCount < uint16(len(micSegmentMap)) {
+		return micSegmentMap[micCount]
+	}
+	return 3
+}
+
+// 自定义段位表 - 段位 k（k>=1）为上麦人数不少于 Bounds[k-1] 的最高段位，0人为段位0
+// 默认分段等价于 Bounds: {1, 4, 7}；最后一段为溢出段位，容纳所有超过最大下限的房间，
+// 例如 {1, 4, 7, 50} 共5个段位，50人以上单独成为段位4
+type SegmentMap struct {
+	Bounds          []uint16 `json:"bounds"`           // 各段位的最低上麦人数，必须从1开始严格递增
+	IsolateOverflow bool     `json:"isolate_overflow"` // 溢出段位只与同段位匹配，不受等待时间放宽影响
+}
+
+// 段位判定 - m 为 nil 时使用默认分段
+func (m *SegmentMap) Segment(micCount uint16) uint8 {
+	if m == nil {
+		return getMicSegment(micCount)
+	}
+	return uint8(sort.Search(len(m.Bounds), func(i int) bool { return m.Bounds[i] > micCount }))
+}
+
+// 溢出段位
+func (m *SegmentMap) overflow() uint8 {
+	return uint8(len(m.Bounds))
+}
+
+// 段位表校验
+func (m *SegmentMap) validate() error {
+	if len(m.Bounds) == 0 || len(m.Bounds) > math.MaxUint8 {
+		return fmt.Errorf("SegmentMap.Bounds must have 1 to %d entries, got %d", math.MaxUint8, len(m.Bounds))
+	}
+	if m.Bounds[0] < 1 {
+		return fmt.Errorf("SegmentMap.Bounds must start at 1 or above, got %d", m.Bounds[0])
+	}
+	for i := 1; i < len(m.Bounds); i++ {
+		if m.Bounds[i] <= m.Bounds[i-1] {
+			return fmt.Errorf("SegmentMap.Bounds must be strictly increasing, got %d after %d", m.Bounds[i], m.Bounds[i-1])
+		}
+	}
+	return nil
+}
+
+// 实体在当前配置下的段位 - 未配置段位表时使用实体缓存的默认段位
+func (c *MatchConfig) segmentOf(e *Entity) uint8 {
+	if c.SegmentMap == nil {
+		return e.Segment()
+	}
+	return c.SegmentMap.Segment(e.MicCount)
+}
+
+// 段位距离 - 转为有符号数再取绝对值，避免 uint8 相减回绕
+func segmentDistance(a, b uint8) int16 {
+	diff := int16(a) - int16(b)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff
+}